@@ -5,12 +5,16 @@
 package cmd
 
 import (
+	"context"
+	"ecr-mirror-sync/pkg/events"
 	mirror "ecr-mirror-sync/pkg/mirror"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/shutdown"
+	"ecr-mirror-sync/pkg/tracing"
 
 	"github.com/spf13/cobra"
 )
@@ -27,30 +31,71 @@ func copyCmd() *cobra.Command {
 	destFlags, destOpts := options.ImageDestFlags(globalOpts, "dest-", "dcreds")
 	retryFlags, retryOpts := options.RetryFlags()
 	mirrorFlags, mirrorOpts := options.MirrorFlags(globalOpts, srcOpts, destOpts, retryOpts)
+	eventsFlags, eventsOpts := options.EventsFlags()
+	notifyFlags, notifyOpts := options.NotifyFlags()
+	reportFlags, reportOpts := options.ReportFlags()
+	tracingFlags, tracingOpts := options.TracingFlags()
 
 	copyCmd := &cobra.Command{
 		Use:   "copy",
 		Short: "Copy image:tag from public source to ECR",
 		Long:  `Copy image:tag from public source to ECR`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			log.Info("copy requested")
 
 			opts := mirrorOpts
 
-			copy := mirror.New(opts)
+			if err := validateReportOptions(reportOpts); err != nil {
+				return err
+			}
+			separateReport(reportOpts, opts)
+			notifier, err := newNotifier(notifyOpts)
+			if err != nil {
+				return err
+			}
+
+			shutdownTracing, err := tracing.Setup(cmd.Context(), tracingOpts)
+			if err != nil {
+				return options.NewConfigError(err)
+			}
+			defer func() {
+				if err := shutdownTracing(context.Background()); err != nil {
+					log.Errorf("could not flush traces: %v", err)
+				}
+			}()
+
+			copy, err := mirror.New(opts)
+			if err != nil {
+				return err
+			}
+			copy.Events = events.NewPublisher(copy.AWSClientSession, eventsOpts.TopicARN, eventsOpts.EventBus, eventsOpts.Source, eventsOpts.Types)
+
+			// An interrupted copy still notifies within the shutdown grace period.
+			notifyCtx, cancelNotify := shutdown.WithGracePeriod(cmd.Context(), opts.ShutdownGrace)
+			defer cancelNotify()
+
 			start := time.Now()
-			copy.Copy(upstreamImageTag, ecrRespository)
+			result, err := copy.Copy(cmd.Context(), upstreamImageTag, ecrRespository)
 			elapsed := time.Since(start)
-			log.Infof("Copy took %s", elapsed)
+			copy.Logger().WithField(mirror.LogFieldDuration, elapsed.Seconds()).Infof("Copy took %s", elapsed)
+			if reportErr := writeReport(reportOpts, "copy", result); reportErr != nil && err == nil {
+				err = reportErr
+			}
+			sendNotifications(notifyCtx, notifier, "copy", result)
+			return err
 		},
 	}
 
 	flags := copyCmd.Flags()
 	flags.AddFlagSet(&globalFlags)
 	flags.AddFlagSet(&destFlags)
+	flags.AddFlagSet(&eventsFlags)
 	flags.AddFlagSet(&mirrorFlags)
+	flags.AddFlagSet(&notifyFlags)
+	flags.AddFlagSet(&reportFlags)
 	flags.AddFlagSet(&retryFlags)
 	flags.AddFlagSet(&srcFlags)
+	flags.AddFlagSet(&tracingFlags)
 	flags.StringVarP(&ecrRespository, "dest", "d", "", "ecr destingation repository")
 	flags.StringVarP(&upstreamImageTag, "src", "s", "", "source image:tag")
 	return copyCmd
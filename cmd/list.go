@@ -9,35 +9,46 @@ import (
 	"ecr-mirror-sync/pkg/options"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/spf13/cobra"
 )
 
 func listCmd() *cobra.Command {
 
 	mirrorFlags, mirrorOpts := options.MirrorFlags(nil, nil, nil, nil)
+	reportFlags, reportOpts := options.ReportFlags()
 
 	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List ECR repositories and tags marked for mirroring",
 		Long:  `List ECR repositories and tags marked for mirroring`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 
 			opts := mirrorOpts
 
+			if err := validateReportOptions(reportOpts); err != nil {
+				return err
+			}
+			separateReport(reportOpts, opts)
+
 			opts.RenderTable = true
-			mirrorRepos := mirror.New(opts)
+			mirrorRepos, err := mirror.New(opts)
+			if err != nil {
+				return err
+			}
 			start := time.Now()
-			mirrorRepos.List()
+			result, err := mirrorRepos.List(cmd.Context())
 			elapsed := time.Since(start)
-			log.Infof("List took %s", elapsed)
-
+			mirrorRepos.Logger().WithField(mirror.LogFieldDuration, elapsed.Seconds()).Infof("List took %s", elapsed)
+			if reportErr := writeReport(reportOpts, "list", result); reportErr != nil && err == nil {
+				err = reportErr
+			}
+			return err
 		},
 	}
 
 	flags := listCmd.Flags()
 	flags.AddFlagSet(&mirrorFlags)
+	flags.AddFlagSet(&reportFlags)
 
 	return listCmd
 }
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/redact"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+const logTimestampFormat = "2006-01-02 15:04:05"
+
+// logFieldMap renames the logrus default keys, shared by the text and json formats.
+var logFieldMap = logrus.FieldMap{
+	logrus.FieldKeyTime:  "timestamp",
+	logrus.FieldKeyLevel: "level",
+	logrus.FieldKeyMsg:   "message",
+}
+
+// configureLogging applies --log-format and --log-level to the standard logrus logger.
+// Every format is wrapped in a redact.Formatter so credentials never reach the logs.
+func configureLogging(opts *options.LogOptions) error {
+	var formatter logrus.Formatter
+
+	switch opts.Format {
+	case options.LogFormatText, "":
+		formatter = &logrus.TextFormatter{
+			DisableColors:   true,
+			TimestampFormat: logTimestampFormat,
+			FullTimestamp:   true,
+			FieldMap:        logFieldMap,
+		}
+	case options.LogFormatJSON:
+		formatter = &logrus.JSONFormatter{
+			TimestampFormat: logTimestampFormat,
+			FieldMap:        logFieldMap,
+		}
+	default:
+		return options.NewConfigError(fmt.Errorf("unknown log format %q, expected %s or %s",
+			opts.Format, options.LogFormatText, options.LogFormatJSON))
+	}
+	logrus.SetFormatter(&redact.Formatter{Formatter: formatter})
+
+	if opts.Level == "" {
+		return nil
+	}
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		return options.NewConfigError(err)
+	}
+	logrus.SetLevel(level)
+	return nil
+}
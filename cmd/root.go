@@ -5,15 +5,33 @@ Copyright © 2022 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"ecr-mirror-sync/pkg/journal"
+	mirror "ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/lock"
+	"ecr-mirror-sync/pkg/notify"
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/report"
+	"ecr-mirror-sync/pkg/shutdown"
+	"ecr-mirror-sync/pkg/state"
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes returned by the ecr-mirror-sync binary.
+const (
+	ExitError          = 1 // unexpected runtime error, e.g. repository discovery failed
+	ExitConfigError    = 2 // invalid flags, credentials or AWS configuration
+	ExitMirrorFailures = 3 // failed mirrors exceeded the --fail-on/--max-failures policy
+)
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the cmd.
 func Execute() {
@@ -22,29 +40,67 @@ func Execute() {
 		return
 	}
 
+	// Errors raised before the flags are parsed are logged in the default text format.
+	_ = configureLogging(&options.LogOptions{Format: options.LogFormatText})
+
+	// SIGINT and SIGTERM cancel the context of the command, e.g. when Kubernetes stops the pod.
+	ctx, stop := shutdown.NotifyContext(context.Background())
+	defer stop()
+
 	cmd, _ := coreOptions()
-	if err := cmd.Execute(); err != nil {
-		logrus.Fatal(err)
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		logrus.Error(err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+func exitCode(err error) int {
+	var (
+		configErr  options.ConfigError
+		failureErr *mirror.FailureError
+		usageErr   options.ErrorShouldDisplayUsage
+	)
+
+	switch {
+	case errors.As(err, &failureErr):
+		return ExitMirrorFailures
+	case errors.As(err, &configErr), errors.As(err, &usageErr):
+		return ExitConfigError
+	default:
+		return ExitError
 	}
 }
 
 //  coreOptions returns a cobra.Command, and the underlying globalOptions object, to be run or tested.
 func coreOptions() (*cobra.Command, *options.GlobalOptions) {
 	globalOpts := options.GlobalOptions{}
+	logFlags, logOpts := options.LogFlags()
 	cmd := &cobra.Command{
 		Use:               "ecr-mirror-sync",
 		Long:              "Tool used to Sync Public Images with ECR Repositories",
 		RunE:              requireSubcommand,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return configureLogging(logOpts)
+		},
 		SilenceUsage:      true,
 		SilenceErrors:     true,
 		CompletionOptions: cobra.CompletionOptions{DisableDefaultCmd: true},
 		TraverseChildren:  true,
 	}
 
+	cmd.PersistentFlags().AddFlagSet(&logFlags)
+
+	// Invalid flag values, e.g. an unknown --fail-on policy, are configuration errors of every subcommand.
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return options.NewConfigError(err)
+	})
+
 	cmd.AddCommand(
 		listCmd(),
 		copyCmd(),
 		syncCmd(),
+		serveCmd(),
 	)
 	return cmd, &globalOpts
 }
@@ -63,3 +119,144 @@ func requireSubcommand(cmd *cobra.Command, args []string) error {
 	}
 	return fmt.Errorf("missing command '%[1]s COMMAND'\nTry '%[1]s --help' for more information", cmd.CommandPath())
 }
+
+// validateReportOptions rejects unknown report formats before any work is done.
+func validateReportOptions(opts *options.ReportOptions) error {
+	if opts.Format != "" && !report.ValidFormat(opts.Format) {
+		return options.NewConfigError(fmt.Errorf("unknown report format %q, expected one of %s, %s or %s",
+			opts.Format, report.FormatJSON, report.FormatJUnit, report.FormatMarkdown))
+	}
+	return nil
+}
+
+// separateReport writes the copy progress and tables of opts to stderr when the report goes to stdout, so
+// that stdout only holds the report.
+func separateReport(reportOpts *options.ReportOptions, opts *options.MirrorOptions) {
+	if reportOpts.ToStdout() {
+		opts.Output = os.Stderr
+	}
+}
+
+// writeReport writes the report of a finished run when --report-format is set.
+func writeReport(opts *options.ReportOptions, command string, result *mirror.Result) error {
+	if opts.Format == "" || result == nil {
+		return nil
+	}
+	rep := result.Report(command)
+	if opts.ChangedOnly {
+		rep = rep.ChangedSinceLastRun()
+	}
+	return report.WriteFile(opts.File, opts.Format, rep)
+}
+
+// attachLock sets the lock configured by opts on mirrorRepos.
+func attachLock(mirrorRepos *mirror.MirrorProvider, opts *options.LockOptions) error {
+	if opts.Location == "" {
+		return nil
+	}
+	if opts.TTL < 3*time.Second {
+		return options.NewConfigError(fmt.Errorf("--lock-ttl %s is too short", opts.TTL))
+	}
+
+	locker, err := lock.Open(mirrorRepos.AWSClientSession, opts.Location)
+	if err != nil {
+		return options.NewConfigError(err)
+	}
+	mirrorRepos.Lock = locker
+	mirrorRepos.LockTTL = opts.TTL
+	return nil
+}
+
+// attachState sets the state store configured by opts on mirrorRepos.
+func attachState(mirrorRepos *mirror.MirrorProvider, opts *options.StateOptions) error {
+	if opts.Location == "" {
+		if opts.TTL > 0 {
+			return options.NewConfigError(errors.New("--state-ttl requires --state"))
+		}
+		return nil
+	}
+
+	store, err := state.Open(mirrorRepos.AWSClientSession, opts.Location, mirrorRepos.Options.RetryOpts)
+	if err != nil {
+		return options.NewConfigError(err)
+	}
+	mirrorRepos.State = store
+	mirrorRepos.StateTTL = opts.TTL
+	return nil
+}
+
+// attachResume sets the journal and the entries to sync configured by opts on mirrorRepos.
+// The caller closes mirrorRepos.Journal once the sync finished.
+func attachResume(mirrorRepos *mirror.MirrorProvider, opts *options.ResumeOptions) error {
+	if opts.RetryFailed != "" {
+		rep, err := report.ReadFile(opts.RetryFailed)
+		if err != nil {
+			return options.NewConfigError(err)
+		}
+		filter, failed := mirror.FailedIn(rep)
+		mirrorRepos.Filter = filter
+		mirrorRepos.Logger().Infof("retrying the %d failed entries of run %s", failed, rep.RunID)
+	}
+
+	if opts.Journal == "" {
+		if opts.Resume {
+			return options.NewConfigError(errors.New("--resume requires --journal"))
+		}
+		return nil
+	}
+
+	resume := false
+	if opts.Resume {
+		progress, err := journal.Load(opts.Journal)
+		if err != nil {
+			return options.NewConfigError(err)
+		}
+		if progress.Unfinished() {
+			resume = true
+			mirrorRepos.Resumed = progress.Completed
+			mirrorRepos.Logger().Infof("resuming the interrupted run %s, %d entries were handled already", progress.RunID, len(progress.Completed))
+		} else {
+			mirrorRepos.Logger().Info("no interrupted run to resume, syncing every entry")
+		}
+	}
+
+	j, err := journal.Open(opts.Journal, resume)
+	if err != nil {
+		return options.NewConfigError(err)
+	}
+	mirrorRepos.Journal = j
+	return nil
+}
+
+// newNotifier returns the webhook notifier configured by opts, or nil when no webhook is set.
+func newNotifier(opts *options.NotifyOptions) (*notify.Notifier, error) {
+	if len(opts.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	webhooks := make([]notify.Webhook, 0, len(opts.Webhooks))
+	for _, spec := range opts.Webhooks {
+		webhook, err := notify.ParseWebhook(spec)
+		if err != nil {
+			return nil, options.NewConfigError(err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	notifier, err := notify.New(webhooks, opts.On, opts.Template)
+	if err != nil {
+		return nil, options.NewConfigError(err)
+	}
+	return notifier, nil
+}
+
+// sendNotifications posts the result of a run to the configured webhooks.
+// Notification failures are logged, they never fail the run.
+func sendNotifications(ctx context.Context, notifier *notify.Notifier, command string, result *mirror.Result) {
+	if notifier == nil || result == nil {
+		return
+	}
+	if err := notifier.Notify(ctx, result.Report(command)); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/daemon"
+	"ecr-mirror-sync/pkg/events"
+	mirror "ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/server"
+	"ecr-mirror-sync/pkg/tracing"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+func serveCmd() *cobra.Command {
+
+	globalFlags, globalOpts := options.GlobalFlags()
+	srcFlags, srcOpts := options.ImageFlags(globalOpts, "src-", "screds")
+	destFlags, destOpts := options.ImageDestFlags(globalOpts, "dest-", "dcreds")
+	retryFlags, retryOpts := options.RetryFlags()
+	mirrorFlags, mirrorOpts := options.MirrorFlags(globalOpts, srcOpts, destOpts, retryOpts)
+	eventsFlags, eventsOpts := options.EventsFlags()
+	notifyFlags, notifyOpts := options.NotifyFlags()
+	lockFlags, lockOpts := options.LockFlags()
+	reportFlags, reportOpts := options.ReportFlags()
+	stateFlags, stateOpts := options.StateFlags()
+	serveFlags, serveOpts := options.ServeFlags()
+	tracingFlags, tracingOpts := options.TracingFlags()
+	metricsFlags, metricsOpts := options.MetricsFlags()
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Keep running, sync all tagged ECR repositories on a schedule and serve on-demand mirror requests",
+		Long: `Keep running and sync all tagged ECR repositories on an interval or cron schedule.
+The AWS session and ECR credentials are reused across syncs, and a sync never starts while the previous one is running.
+With --listen, an http api lists the discovered mirrors and copies requested images on demand.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+
+			err := validateReportOptions(reportOpts)
+			if err != nil {
+				return err
+			}
+			separateReport(reportOpts, mirrorOpts)
+			if !serveOpts.Scheduled() && serveOpts.Listen == "" {
+				return options.NewConfigError(errors.New("serve needs an --interval, a --schedule or an api --listen address"))
+			}
+			var schedule daemon.Schedule
+			if serveOpts.Scheduled() {
+				schedule, err = daemon.NewSchedule(serveOpts.Interval, serveOpts.Schedule)
+				if err != nil {
+					return options.NewConfigError(err)
+				}
+			}
+			auth, err := newAuthenticator(serveOpts)
+			if err != nil {
+				return err
+			}
+			var webhookSecret string
+			if serveOpts.WebhookSecretFile != "" {
+				if webhookSecret, err = server.LoadWebhookSecret(serveOpts.WebhookSecretFile); err != nil {
+					return options.NewConfigError(err)
+				}
+			}
+			notifier, err := newNotifier(notifyOpts)
+			if err != nil {
+				return err
+			}
+
+			shutdownTracing, err := tracing.Setup(cmd.Context(), tracingOpts)
+			if err != nil {
+				return options.NewConfigError(err)
+			}
+			defer func() {
+				if err := shutdownTracing(context.Background()); err != nil {
+					log.Errorf("could not flush traces: %v", err)
+				}
+			}()
+
+			mirrorRepos, err := mirror.New(mirrorOpts)
+			if err != nil {
+				return err
+			}
+			mirrorRepos.Events = events.NewPublisher(mirrorRepos.AWSClientSession, eventsOpts.TopicARN, eventsOpts.EventBus, eventsOpts.Source, eventsOpts.Types)
+			if err := attachLock(mirrorRepos, lockOpts); err != nil {
+				return err
+			}
+			if err := attachState(mirrorRepos, stateOpts); err != nil {
+				return err
+			}
+
+			// The scheduler and the api run side by side, either one failing stops the other. Scheduled syncs
+			// and api jobs share a queue, so they never overlap.
+			group, ctx := errgroup.WithContext(cmd.Context())
+			queue := daemon.NewQueue()
+			group.Go(func() error { return queue.Start(ctx) })
+
+			var api *server.Server
+			if serveOpts.Listen != "" {
+				api = server.New(queue, server.MirrorProvider{MirrorProvider: mirrorRepos}, auth, webhookSecret)
+				group.Go(func() error { return api.ListenAndServe(ctx, serveOpts.Listen) })
+			}
+			if schedule != nil {
+				scheduler := &daemon.Scheduler{
+					Jitter:     serveOpts.Jitter,
+					RunOnStart: serveOpts.RunOnStart,
+					Schedule:   schedule,
+					Run: func(ctx context.Context) error {
+						return queue.Do(ctx, func(ctx context.Context) error {
+							result, err := runSync(ctx, mirrorRepos.NewRun(), metricsOpts, reportOpts, notifier)
+							if api != nil {
+								api.Observe("sync", result, err)
+							}
+							return err
+						})
+					},
+				}
+				log.Info("serving scheduled syncs...")
+				group.Go(func() error { return scheduler.Start(ctx) })
+			}
+			return group.Wait()
+		},
+	}
+
+	flags := serveCmd.Flags()
+	flags.AddFlagSet(&globalFlags)
+	flags.AddFlagSet(&destFlags)
+	flags.AddFlagSet(&eventsFlags)
+	flags.AddFlagSet(&lockFlags)
+	flags.AddFlagSet(&metricsFlags)
+	flags.AddFlagSet(&mirrorFlags)
+	flags.AddFlagSet(&notifyFlags)
+	flags.AddFlagSet(&reportFlags)
+	flags.AddFlagSet(&retryFlags)
+	flags.AddFlagSet(&serveFlags)
+	flags.AddFlagSet(&srcFlags)
+	flags.AddFlagSet(&stateFlags)
+	flags.AddFlagSet(&tracingFlags)
+	return serveCmd
+}
+
+// newAuthenticator returns the api authenticator configured by opts. An api without an --api-token-file
+// is refused unless --api-allow-unauthenticated is set, then every request is accepted.
+func newAuthenticator(opts *options.ServeOptions) (server.Authenticator, error) {
+	if opts.APITokenFile == "" {
+		if opts.Listen != "" && !opts.AllowUnauthenticated {
+			return nil, options.NewConfigError(errors.New("--listen requires an --api-token-file, or --api-allow-unauthenticated to serve the api without authentication"))
+		}
+		if opts.Listen != "" {
+			log.Warn("--api-allow-unauthenticated is set, the api accepts unauthenticated requests")
+		}
+		return server.AllowAll{}, nil
+	}
+
+	tokens, err := server.LoadBearerTokens(opts.APITokenFile)
+	if err != nil {
+		return nil, options.NewConfigError(err)
+	}
+	return tokens, nil
+}
@@ -5,8 +5,14 @@
 package cmd
 
 import (
+	"context"
+	"ecr-mirror-sync/pkg/events"
+	"ecr-mirror-sync/pkg/metrics"
 	mirror "ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/notify"
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/shutdown"
+	"ecr-mirror-sync/pkg/tracing"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -20,30 +26,107 @@ func syncCmd() *cobra.Command {
 	destFlags, destOpts := options.ImageDestFlags(globalOpts, "dest-", "dcreds")
 	retryFlags, retryOpts := options.RetryFlags()
 	mirrorFlags, mirrorOpts := options.MirrorFlags(globalOpts, srcOpts, destOpts, retryOpts)
+	eventsFlags, eventsOpts := options.EventsFlags()
+	notifyFlags, notifyOpts := options.NotifyFlags()
+	lockFlags, lockOpts := options.LockFlags()
+	reportFlags, reportOpts := options.ReportFlags()
+	resumeFlags, resumeOpts := options.ResumeFlags()
+	stateFlags, stateOpts := options.StateFlags()
+	tracingFlags, tracingOpts := options.TracingFlags()
+	metricsFlags, metricsOpts := options.MetricsFlags()
 
 	syncCmd := &cobra.Command{
 		Use:   "sync",
 		Short: "Sync all ECR repositories tagged to be mirror with public repositories",
 		Long:  `Sync all ECR repositories tagged to be mirror with public repositories`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 
 			log.Info("syncing external repositories...")
 
 			opts := mirrorOpts
 
-			mirrorRepos := mirror.New(opts)
-			start := time.Now()
-			mirrorRepos.Sync()
-			elapsed := time.Since(start)
-			log.Infof("Sync Completed.Sync took %s", elapsed)
+			if err := validateReportOptions(reportOpts); err != nil {
+				return err
+			}
+			separateReport(reportOpts, opts)
+			notifier, err := newNotifier(notifyOpts)
+			if err != nil {
+				return err
+			}
+
+			shutdownTracing, err := tracing.Setup(cmd.Context(), tracingOpts)
+			if err != nil {
+				return options.NewConfigError(err)
+			}
+			defer func() {
+				if err := shutdownTracing(context.Background()); err != nil {
+					log.Errorf("could not flush traces: %v", err)
+				}
+			}()
+
+			mirrorRepos, err := mirror.New(opts)
+			if err != nil {
+				return err
+			}
+			mirrorRepos.Events = events.NewPublisher(mirrorRepos.AWSClientSession, eventsOpts.TopicARN, eventsOpts.EventBus, eventsOpts.Source, eventsOpts.Types)
+			if err := attachLock(mirrorRepos, lockOpts); err != nil {
+				return err
+			}
+			if err := attachState(mirrorRepos, stateOpts); err != nil {
+				return err
+			}
+			if err := attachResume(mirrorRepos, resumeOpts); err != nil {
+				return err
+			}
+			defer mirrorRepos.Journal.Close()
+
+			_, err = runSync(cmd.Context(), mirrorRepos, metricsOpts, reportOpts, notifier)
+			return err
 		},
 	}
 
 	flags := syncCmd.Flags()
 	flags.AddFlagSet(&globalFlags)
 	flags.AddFlagSet(&destFlags)
+	flags.AddFlagSet(&eventsFlags)
+	flags.AddFlagSet(&lockFlags)
+	flags.AddFlagSet(&metricsFlags)
 	flags.AddFlagSet(&mirrorFlags)
+	flags.AddFlagSet(&notifyFlags)
+	flags.AddFlagSet(&reportFlags)
+	flags.AddFlagSet(&resumeFlags)
 	flags.AddFlagSet(&retryFlags)
 	flags.AddFlagSet(&srcFlags)
+	flags.AddFlagSet(&stateFlags)
+	flags.AddFlagSet(&tracingFlags)
 	return syncCmd
 }
+
+// runSync runs a single sync with mirrorRepos, then exports its metrics, writes its report and sends its notifications.
+func runSync(ctx context.Context, mirrorRepos *mirror.MirrorProvider, metricsOpts *options.MetricsOptions, reportOpts *options.ReportOptions, notifier *notify.Notifier) (*mirror.Result, error) {
+	if metricsOpts.Enabled() {
+		mirrorRepos.Metrics = metrics.New()
+	}
+
+	// An interrupted sync still notifies within the shutdown grace period.
+	notifyCtx, cancelNotify := shutdown.WithGracePeriod(ctx, mirrorRepos.Options.ShutdownGrace)
+	defer cancelNotify()
+
+	start := time.Now()
+	result, err := mirrorRepos.Sync(ctx)
+	if result == nil && err == nil {
+		return nil, nil // skipped, another sync holds the lock
+	}
+	elapsed := time.Since(start)
+	mirrorRepos.Logger().WithField(mirror.LogFieldDuration, elapsed.Seconds()).Infof("Sync Completed. Sync took %s", elapsed)
+
+	mirrorRepos.Metrics.ObserveRun(elapsed)
+	if metricsErr := mirrorRepos.Metrics.Export(metricsOpts.PushgatewayURL, metricsOpts.Job, metricsOpts.Textfile); metricsErr != nil {
+		mirrorRepos.Logger().Error(metricsErr)
+	}
+	if reportErr := writeReport(reportOpts, "sync", result); reportErr != nil && err == nil {
+		err = reportErr
+	}
+	sendNotifications(notifyCtx, notifier, "sync", result)
+	return result, err
+}
@@ -1,24 +1,32 @@
 package containers
 
 import (
+	"context"
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/retry"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
 	"strings"
+	"time"
 
-	"github.com/containers/common/pkg/retry"
 	"github.com/containers/image/v5/copy"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/transports/alltransports"
-	log "github.com/sirupsen/logrus"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 )
 
+// progressInterval is how often copy.Image reports partial blob progress, we only account for completed blobs.
+const progressInterval = 10 * time.Second
+
 func NewCopyProvider(options *options.MirrorOptions) *Copy {
 	return &Copy{
 		additionalTags:   []string{},
 		destImage:        options.DestImage,
 		global:           options.Global,
+		limiter:          newLimiter(options.BandwidthLimit),
 		quiet:            options.Quiet,
 		removeSignatures: options.RemoveSignatures,
 		retryOpts:        options.RetryOpts,
@@ -26,53 +34,61 @@ func NewCopyProvider(options *options.MirrorOptions) *Copy {
 	}
 }
 
-func (opts *Copy) Copy(args []string, stdout io.Writer) (retErr error) {
+// Copy copies args[0] to args[1] and returns the number of blob bytes transferred and the digest of the copied manifest.
+// The copy is abandoned when ctx is canceled.
+func (opts *Copy) Copy(ctx context.Context, args []string, stdout io.Writer) (bytesTransferred uint64, copied digest.Digest, retErr error) {
+
+	// Copy is shared by concurrent workers, credentials are only ever dropped on a per call copy of the source options.
+	srcImage := opts.srcImage
 
 	// When Syncing a combinationn of images from multiple repositories, we favor dockerhub when passing credentials,
 	// we expect that the other repositories are accessible anonymously.
 	anonymous, _ := regexp.MatchString(`([^\s]+)\.([^\s]+)\/([^\s]+)`, strings.TrimPrefix(args[0], "docker://"))
 
-	if anonymous && !strings.Contains(args[0], "docker.io") &&
-		opts.srcImage.DockerImageOptions.Transport == "docker" &&
-		opts.srcImage.DockerImageOptions.Global.AuthFilePath == "" {
+	if anonymous && !opts.withinDestination && !strings.Contains(args[0], "docker.io") &&
+		srcImage.DockerImageOptions.Transport == "docker" &&
+		srcImage.DockerImageOptions.Global.AuthFilePath == "" {
 
-		opts.srcImage.DockerImageOptions.CredsOption = ""
+		srcImage.DockerImageOptions.CredsOption = ""
 	}
 
 	if len(args) != 2 {
-		log.Error("Exactly two arguments expected")
+		return 0, "", errors.New("Exactly two arguments expected")
 	}
 	imageNames := args
 
 	policyContext, retErr := opts.global.GetPolicyContext()
 	if retErr != nil {
-		log.Error("Error loading trust policy: %v", retErr)
+		return 0, "", fmt.Errorf("Error loading trust policy: %v", retErr)
 	}
 	defer func() {
-		if retErr := policyContext.Destroy(); retErr != nil {
-			retErr = fmt.Errorf("(error tearing down policy context: %v): %w", retErr, retErr)
+		if err := policyContext.Destroy(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("error tearing down policy context: %w", err)
 		}
 	}()
 
 	srcRef, retErr := alltransports.ParseImageName(imageNames[0])
 	if retErr != nil {
-		log.Error("Invalid source name %s: %v", imageNames[0], retErr)
+		return 0, "", fmt.Errorf("Invalid source name %s: %v", imageNames[0], retErr)
+	}
+	if opts.limiter != nil {
+		srcRef = throttledReference{ImageReference: srcRef, limiter: opts.limiter}
 	}
 	destRef, retErr := alltransports.ParseImageName(imageNames[1])
 	if retErr != nil {
-		log.Error("Invalid destination name %s: %v", imageNames[1], retErr)
+		return 0, "", fmt.Errorf("Invalid destination name %s: %v", imageNames[1], retErr)
 	}
 
-	srcCtx, retErr := opts.srcImage.NewSystemContext()
+	srcCtx, retErr := srcImage.NewSystemContext()
 	if retErr != nil {
-		return retErr
+		return 0, "", retErr
 	}
 	destCtx, retErr := opts.destImage.NewSystemContext()
 	if retErr != nil {
-		return retErr
+		return 0, "", retErr
 	}
 
-	ctx, cancel := opts.global.TimeoutContext()
+	ctx, cancel := opts.global.TimeoutContext(ctx)
 	defer cancel()
 
 	if opts.quiet {
@@ -81,12 +97,27 @@ func (opts *Copy) Copy(args []string, stdout io.Writer) (retErr error) {
 
 	imageListSelection := copy.CopySystemImage
 
-	return retry.RetryIfNecessary(ctx, func() error {
-		_, retErr := copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
+	// Blob transfers are reported on the progress channel, completed ones are summed up to
+	// the number of bytes transferred, including those of attempts which were retried.
+	progress := make(chan types.ProgressProperties)
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		for p := range progress {
+			if p.Event == types.ProgressEventDone {
+				bytesTransferred += p.Offset
+			}
+		}
+	}()
+
+	retErr = retry.Do(ctx, opts.retryOpts, func() error {
+		copiedManifest, retErr := copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
 			DestinationCtx:        destCtx,
 			ForceManifestMIMEType: manifest.DockerV2Schema2MediaType,
 			ImageListSelection:    imageListSelection,
 			PreserveDigests:       true,
+			Progress:              progress,
+			ProgressInterval:      progressInterval,
 			RemoveSignatures:      false,
 			ReportWriter:          stdout,
 			SourceCtx:             srcCtx,
@@ -95,6 +126,22 @@ func (opts *Copy) Copy(args []string, stdout io.Writer) (retErr error) {
 			return retErr
 		}
 
+		copied, retErr = manifest.Digest(copiedManifest)
 		return retErr
-	}, opts.retryOpts)
+	})
+
+	close(progress)
+	<-progressDone
+
+	return bytesTransferred, copied, retErr
+}
+
+// CopyWithinDestination copies args[0], an image already copied into the destination registry, to args[1]. The source
+// is read with the destination credentials, within a registry the blobs are mounted across repositories rather than
+// uploaded again.
+func (opts *Copy) CopyWithinDestination(ctx context.Context, args []string, stdout io.Writer) (uint64, digest.Digest, error) {
+	within := *opts
+	within.srcImage = *opts.destImage.ImageOptions
+	within.withinDestination = true
+	return within.Copy(ctx, args, stdout)
 }
@@ -1,13 +1,17 @@
 package containers
 
 import (
+	"context"
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/retry"
 	"fmt"
 	"regexp"
 	"strings"
 
-	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
@@ -21,35 +25,26 @@ func NewManifestProvider(options options.ManifestOptions) *Manifest {
 	}
 }
 
-func (opts *Manifest) Manifest(args []string) (rawManifest []byte, err error) {
+// Manifest returns the raw manifest of args[0], the lookup is abandoned when ctx is canceled.
+func (opts *Manifest) Manifest(ctx context.Context, args []string) (rawManifest []byte, err error) {
 	var (
 		src types.ImageSource
 	)
 
-	// When Syncing a combinationn of images from multiple repositories, we favor dockerhub when using command line flags to pass credentials
-	// we expect that the other repositories are accessible anonymously
-	anonymous, _ := regexp.MatchString(`([^\s]+)\.([^\s]+)\/([^\s]+)`, strings.TrimPrefix(args[0], "docker://"))
-
-	if anonymous && !strings.Contains(args[0], "docker.io") &&
-		opts.image.DockerImageOptions.Transport == "docker" &&
-		opts.image.DockerImageOptions.Global.AuthFilePath == "" {
-
-		opts.image.DockerImageOptions.CredsOption = ""
-	}
-
-	ctx, cancel := opts.global.TimeoutContext()
-	defer cancel()
-
 	if len(args) != 1 {
 		return rawManifest, errors.New("Exactly one argument expected")
 	}
+	opts.dropCredentials(args[0])
+
+	ctx, cancel := opts.global.TimeoutContext(ctx)
+	defer cancel()
 
 	imageName := args[0]
 
-	if err := retry.RetryIfNecessary(ctx, func() error {
+	if err := retry.Do(ctx, opts.retryOpts, func() error {
 		src, err = options.ParseImageSource(ctx, &opts.image, imageName)
 		return err
-	}, opts.retryOpts); err != nil {
+	}); err != nil {
 		return rawManifest, errors.Wrapf(err, "Error parsing image name %q", imageName)
 	}
 
@@ -59,12 +54,54 @@ func (opts *Manifest) Manifest(args []string) (rawManifest []byte, err error) {
 		}
 	}()
 
-	if err := retry.RetryIfNecessary(ctx, func() error {
+	if err := retry.Do(ctx, opts.retryOpts, func() error {
 		rawManifest, _, err = src.GetManifest(ctx, nil)
 		return err
-	}, opts.retryOpts); err != nil {
+	}); err != nil {
 		return rawManifest, errors.Wrapf(err, "Error retrieving manifest for image")
 	}
 
 	return rawManifest, nil
 }
+
+// Digest returns the manifest digest of image, read with a HEAD request. Registries answer it without sending
+// the manifest and Docker Hub does not count it as a pull, but the digest of a multi-arch image is the one of
+// its manifest list rather than the one of the image for this platform.
+func (opts *Manifest) Digest(ctx context.Context, image string) (manifestDigest digest.Digest, err error) {
+	opts.dropCredentials(image)
+
+	ref, err := alltransports.ParseImageName(image)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing image name %q", image)
+	}
+	if ref.Transport().Name() != docker.Transport.Name() {
+		return "", fmt.Errorf("%s does not support HEAD requests", ref.Transport().Name())
+	}
+	sys, err := opts.image.NewSystemContext()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := opts.global.TimeoutContext(ctx)
+	defer cancel()
+
+	err = retry.Do(ctx, opts.retryOpts, func() error {
+		manifestDigest, err = docker.GetDigest(ctx, sys, ref)
+		return err
+	})
+	return manifestDigest, err
+}
+
+// dropCredentials drops the command line credentials when image is not on Docker Hub. When syncing a combination
+// of images from multiple repositories, we favor dockerhub when using command line flags to pass credentials,
+// we expect that the other repositories are accessible anonymously.
+func (opts *Manifest) dropCredentials(image string) {
+	anonymous, _ := regexp.MatchString(`([^\s]+)\.([^\s]+)\/([^\s]+)`, strings.TrimPrefix(image, "docker://"))
+
+	if anonymous && !strings.Contains(image, "docker.io") &&
+		opts.image.DockerImageOptions.Transport == "docker" &&
+		opts.image.DockerImageOptions.Global.AuthFilePath == "" {
+
+		opts.image.DockerImageOptions.CredsOption = ""
+	}
+}
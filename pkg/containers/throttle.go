@@ -0,0 +1,71 @@
+package containers
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/v5/types"
+	"golang.org/x/time/rate"
+)
+
+// maxBurst bounds the bytes a throttled read may take at once, so the limit is kept smoothly.
+const maxBurst = 256 * 1024
+
+// newLimiter returns a limiter of bytesPerSecond, nil when it is 0.
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := maxBurst
+	if bytesPerSecond < maxBurst {
+		burst = int(bytesPerSecond)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// throttledReference is an image reference whose blobs are read no faster than limiter allows.
+// Every copy shares the same limiter, so the limit applies to all workers together.
+type throttledReference struct {
+	types.ImageReference
+	limiter *rate.Limiter
+}
+
+func (r throttledReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := r.ImageReference.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return throttledSource{ImageSource: src, limiter: r.limiter}, nil
+}
+
+type throttledSource struct {
+	types.ImageSource
+	limiter *rate.Limiter
+}
+
+func (s throttledSource) GetBlob(ctx context.Context, info types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	blob, size, err := s.ImageSource.GetBlob(ctx, info, cache)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &throttledReader{ReadCloser: blob, ctx: ctx, limiter: s.limiter}, size, nil
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
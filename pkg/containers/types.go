@@ -2,23 +2,26 @@ package containers
 
 import (
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/retry"
 
-	"github.com/containers/common/pkg/retry"
+	"golang.org/x/time/rate"
 )
 
 type Copy struct {
-	additionalTags   []string // For docker-archive: destinations, in addition to the name:tag specified as destination, also add these
-	destImage        *options.ImageDestOptions
-	global           *options.GlobalOptions
-	quiet            bool // Suppress output information when copying images
-	removeSignatures bool // Do not copy signatures from the source image
-	retryOpts        *retry.RetryOptions
-	srcImage         options.ImageOptions
+	additionalTags    []string // For docker-archive: destinations, in addition to the name:tag specified as destination, also add these
+	destImage         *options.ImageDestOptions
+	global            *options.GlobalOptions
+	limiter           *rate.Limiter // Optional, shared by every copy to limit the blob bandwidth
+	quiet             bool          // Suppress output information when copying images
+	removeSignatures  bool          // Do not copy signatures from the source image
+	retryOpts         *retry.Options
+	srcImage          options.ImageOptions
+	withinDestination bool // The source is in the destination registry, its credentials are kept
 }
 
 type Manifest struct {
 	global        *options.GlobalOptions
 	image         options.ImageOptions
-	retryOpts     *retry.RetryOptions
+	retryOpts     *retry.Options
 	doNotListTags bool // Do not list all tags available in the same repository
 }
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+)
+
+// queueSize bounds the runs waiting in a Queue.
+const queueSize = 100
+
+// ErrQueueFull is returned when a run is submitted to a Queue which has queueSize runs waiting.
+var ErrQueueFull = errors.New("too many queued runs, try again later")
+
+// Queue runs the scheduled syncs, on-demand copies and webhook syncs of a serve process one at a time,
+// in the order they were submitted, so they never overlap.
+type Queue struct {
+	runs chan func(ctx context.Context)
+}
+
+func NewQueue() *Queue {
+	return &Queue{runs: make(chan func(ctx context.Context), queueSize)}
+}
+
+// Submit queues run without waiting for it, it returns ErrQueueFull when too many runs are waiting already.
+func (q *Queue) Submit(run func(ctx context.Context)) error {
+	select {
+	case q.runs <- run:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Do queues run and waits until it completed, it returns the error of run or nil once ctx is done.
+func (q *Queue) Do(ctx context.Context, run func(ctx context.Context) error) error {
+	var err error
+	done := make(chan struct{})
+	wrapped := func(ctx context.Context) {
+		defer close(done)
+		err = run(ctx)
+	}
+
+	select {
+	case q.runs <- wrapped:
+	case <-ctx.Done():
+		return nil
+	}
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// Start blocks and runs the queued runs until ctx is done, runs still waiting then are dropped.
+func (q *Queue) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case run := <-q.runs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			run(ctx)
+		}
+	}
+}
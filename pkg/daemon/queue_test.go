@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueSubmitWhenFull(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < queueSize; i++ {
+		if err := q.Submit(func(ctx context.Context) {}); err != nil {
+			t.Fatalf("Submit of run %d returned %v", i+1, err)
+		}
+	}
+	if err := q.Submit(func(ctx context.Context) {}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Submit to a full queue returned %v, want %v", err, ErrQueueFull)
+	}
+
+	// A run which was taken from the queue makes room for another.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Start(ctx)
+	if err := q.Do(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Submit(func(ctx context.Context) {}); err != nil {
+		t.Errorf("Submit once the queue drained returned %v", err)
+	}
+}
+
+func TestQueueRunsOneAtATimeInOrder(t *testing.T) {
+	q := NewQueue()
+	var running, overlaps int32
+	var order []int
+	for i := 0; i < 10; i++ {
+		i := i
+		if err := q.Submit(func(ctx context.Context) {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			defer atomic.AddInt32(&running, -1)
+			time.Sleep(time.Millisecond)
+			order = append(order, i)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Start(ctx)
+
+	failed := errors.New("copy failed")
+	if err := q.Do(ctx, func(ctx context.Context) error { return failed }); err != failed {
+		t.Errorf("Do returned %v, want the error of the run", err)
+	}
+	if overlaps != 0 {
+		t.Errorf("%d runs started while another was running", overlaps)
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("runs completed in order %v, want the order they were submitted", order)
+		}
+	}
+	if len(order) != 10 {
+		t.Errorf("%d of 10 runs completed before the run submitted after them", len(order))
+	}
+}
+
+func TestQueueDoReturnsWhenCanceled(t *testing.T) {
+	q := NewQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Nothing takes runs from the queue, Do gives up once ctx is done.
+	if err := q.Do(ctx, func(ctx context.Context) error { return errors.New("never run") }); err != nil {
+		t.Errorf("Do of a canceled run returned %v", err)
+	}
+}
+
+func TestQueueStartDropsRunsOnceCanceled(t *testing.T) {
+	q := NewQueue()
+	ran := false
+	if err := q.Submit(func(ctx context.Context) { ran = true }); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("a run was started after the context was done")
+	}
+}
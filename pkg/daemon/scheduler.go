@@ -0,0 +1,88 @@
+// Package daemon runs syncs on a schedule from a long running process.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schedule returns the next time a run starts after t.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule starts a run every interval after the previous run finished.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// NewSchedule returns the schedule for either an interval or a standard five field cron spec.
+func NewSchedule(interval time.Duration, spec string) (Schedule, error) {
+	switch {
+	case interval > 0 && spec != "":
+		return nil, errors.New("an interval and a schedule are mutually exclusive")
+	case interval > 0:
+		return intervalSchedule(interval), nil
+	case interval < 0:
+		return nil, fmt.Errorf("invalid interval %s", interval)
+	case spec != "":
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		return schedule, nil
+	default:
+		return nil, errors.New("either an interval or a schedule is required")
+	}
+}
+
+// Scheduler calls Run on Schedule until its context is done.
+// Runs never overlap, a run which outlasts the schedule skips the runs it missed.
+type Scheduler struct {
+	Jitter     time.Duration // Random delay of up to Jitter added to every start
+	Run        func(ctx context.Context) error
+	RunOnStart bool // Run once immediately instead of waiting for the first scheduled time
+	Schedule   Schedule
+}
+
+// Start blocks and runs s until ctx is done. Errors of a run are logged, they do not stop the scheduler.
+func (s *Scheduler) Start(ctx context.Context) error {
+	next := time.Now()
+	if !s.RunOnStart {
+		next = s.Schedule.Next(next)
+	}
+
+	for {
+		start := next.Add(s.jitter())
+		log.WithField("next_run", start.Format(time.RFC3339)).Info("waiting for the next scheduled sync")
+
+		timer := time.NewTimer(time.Until(start))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		if err := s.Run(ctx); err != nil {
+			log.Error(err)
+		}
+		next = s.Schedule.Next(time.Now())
+	}
+}
+
+// jitter returns a random delay in [0, s.Jitter).
+func (s *Scheduler) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.Jitter)))
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewSchedule(t *testing.T) {
+	from := time.Date(2022, 5, 17, 13, 20, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name     string
+		interval time.Duration
+		spec     string
+		next     time.Time
+		invalid  bool
+	}{
+		{"interval", 30 * time.Minute, "", from.Add(30 * time.Minute), false},
+		{"cron", 0, "0 */6 * * *", time.Date(2022, 5, 17, 18, 0, 0, 0, time.UTC), false},
+		{"cron weekdays", 0, "30 2 * * 1-5", time.Date(2022, 5, 18, 2, 30, 0, 0, time.UTC), false},
+		{"descriptor", 0, "@hourly", time.Date(2022, 5, 17, 14, 0, 0, 0, time.UTC), false},
+		{"invalid cron", 0, "0 */6 * *", time.Time{}, true},
+		{"seconds field", 0, "0 0 */6 * * *", time.Time{}, true},
+		{"interval and cron", time.Hour, "0 * * * *", time.Time{}, true},
+		{"negative interval", -time.Minute, "", time.Time{}, true},
+		{"neither", 0, "", time.Time{}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			schedule, err := NewSchedule(c.interval, c.spec)
+			if c.invalid {
+				if err == nil {
+					t.Fatalf("NewSchedule(%s, %q) accepted an invalid schedule", c.interval, c.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from); !got.Equal(c.next) {
+				t.Errorf("Next(%s) = %s, want %s", from, got, c.next)
+			}
+		})
+	}
+}
+
+func TestSchedulerNeverOverlaps(t *testing.T) {
+	var running, overlaps, runs int32
+	s := &Scheduler{
+		Run: func(ctx context.Context) error {
+			if atomic.AddInt32(&running, 1) > 1 {
+				atomic.AddInt32(&overlaps, 1)
+			}
+			defer atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&runs, 1)
+			time.Sleep(30 * time.Millisecond)
+			return errors.New("a failed run does not stop the scheduler")
+		},
+		RunOnStart: true,
+		Schedule:   intervalSchedule(time.Millisecond),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if overlaps != 0 {
+		t.Errorf("%d runs started while another was running", overlaps)
+	}
+	// The schedule is due every millisecond, the runs it missed while a run was going on are skipped.
+	if runs < 2 || runs > 8 {
+		t.Errorf("%d runs in 200ms of 30ms runs, want the missed ones skipped", runs)
+	}
+}
+
+func TestSchedulerWaitsForTheSchedule(t *testing.T) {
+	var runs int32
+	s := &Scheduler{
+		Run: func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+		Schedule: intervalSchedule(time.Hour),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 0 {
+		t.Errorf("%d runs before the first scheduled time", runs)
+	}
+}
+
+func TestSchedulerRunsOnStartWithinTheJitter(t *testing.T) {
+	started := make(chan time.Time, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &Scheduler{
+		Jitter: 50 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			started <- time.Now()
+			cancel()
+			return nil
+		},
+		RunOnStart: true,
+		Schedule:   intervalSchedule(time.Hour),
+	}
+
+	begin := time.Now()
+	done := make(chan error)
+	go func() { done <- s.Start(ctx) }()
+
+	select {
+	case at := <-started:
+		if delay := at.Sub(begin); delay > s.Jitter+100*time.Millisecond {
+			t.Errorf("the first run started after %s, want at most the %s jitter", delay, s.Jitter)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first run did not start")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJitterBounds(t *testing.T) {
+	if got := (&Scheduler{}).jitter(); got != 0 {
+		t.Errorf("jitter without Jitter = %s, want 0", got)
+	}
+
+	s := &Scheduler{Jitter: 10 * time.Millisecond}
+	var min, max time.Duration = s.Jitter, 0
+	for i := 0; i < 1000; i++ {
+		d := s.jitter()
+		if d < 0 || d >= s.Jitter {
+			t.Fatalf("jitter = %s, want a delay in [0, %s)", d, s.Jitter)
+		}
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	if max-min < s.Jitter/2 {
+		t.Errorf("1000 jitters spread over [%s, %s], want them spread over [0, %s)", min, max, s.Jitter)
+	}
+}
@@ -0,0 +1,180 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// Event types published for a mirrored image.
+// The exact outcome of the run is in the Outcome of the event, e.g. skipped-cached for a skipped image.
+const (
+	TypeCopied   = "copied"   // the image was missing in ECR and has been copied
+	TypeDeferred = "deferred" // the docker hub pull budget was spent, the image is left to a later run
+	TypeDryRun   = "dry-run"  // the image was not copied because of --dry-run
+	TypeFailed   = "failed"   // mirroring the image failed
+	TypeMutated  = "mutated"  // the upstream digest changed and the ECR image has been replaced
+	TypeSkipped  = "skipped"  // the ECR image matches upstream or could not be compared, it was left as is
+)
+
+// DetailType is the EventBridge detail-type of mirror events.
+const DetailType = "ECR Mirror Image"
+
+// maxPutEventsEntries is the number of entries EventBridge accepts per PutEvents call.
+const maxPutEventsEntries = 10
+
+// Event describes what happened to a single image during a run.
+type Event struct {
+	ECRReference    string    `json:"ecrReference"`
+	Error           string    `json:"error,omitempty"`
+	NewDigest       string    `json:"newDigest,omitempty"`
+	OldDigest       string    `json:"oldDigest,omitempty"`
+	Outcome         string    `json:"outcome"`
+	RunID           string    `json:"runId"`
+	SourceReference string    `json:"sourceReference"`
+	Time            time.Time `json:"time"`
+	Type            string    `json:"type"`
+}
+
+// Publisher delivers mirror events to downstream consumers.
+type Publisher interface {
+	Publish(ctx context.Context, events []Event) error
+}
+
+// NewPublisher returns a Publisher for the SNS topic and/or EventBridge bus using sess.
+// It returns nil when neither is configured.
+func NewPublisher(sess *session.Session, topicARN, eventBus, source string, types []string) Publisher {
+	var publishers multiPublisher
+
+	if topicARN != "" {
+		publishers = append(publishers, &SNSPublisher{Client: sns.New(sess), TopicARN: topicARN})
+	}
+	if eventBus != "" {
+		publishers = append(publishers, &EventBridgePublisher{Client: eventbridge.New(sess), EventBus: eventBus, Source: source})
+	}
+	if len(publishers) == 0 {
+		return nil
+	}
+	return &filteredPublisher{publisher: publishers, types: types}
+}
+
+// filteredPublisher only forwards events of the given types, all events when types is empty.
+type filteredPublisher struct {
+	publisher Publisher
+	types     []string
+}
+
+func (f *filteredPublisher) Publish(ctx context.Context, events []Event) error {
+	if len(f.types) == 0 {
+		return f.publisher.Publish(ctx, events)
+	}
+
+	var filtered []Event
+	for _, event := range events {
+		for _, eventType := range f.types {
+			if event.Type == eventType {
+				filtered = append(filtered, event)
+				break
+			}
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+	return f.publisher.Publish(ctx, filtered)
+}
+
+// multiPublisher publishes to every publisher, even if some of them fail.
+type multiPublisher []Publisher
+
+func (m multiPublisher) Publish(ctx context.Context, events []Event) error {
+	var errs []string
+	for _, publisher := range m {
+		if err := publisher.Publish(ctx, events); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not publish events: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SNSPublisher publishes one message per event to an SNS topic.
+// The event type and outcome are set as the "type" and "outcome" message attributes so subscriptions can filter on them.
+type SNSPublisher struct {
+	Client   snsiface.SNSAPI
+	TopicARN string
+}
+
+func (s *SNSPublisher) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		message, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.Client.PublishWithContext(ctx, &sns.PublishInput{
+			Message: aws.String(string(message)),
+			MessageAttributes: map[string]*sns.MessageAttributeValue{
+				"outcome": {DataType: aws.String("String"), StringValue: aws.String(event.Outcome)},
+				"type":    {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+			},
+			Subject:  aws.String(fmt.Sprintf("%s %s", DetailType, event.Type)),
+			TopicArn: aws.String(s.TopicARN),
+		})
+		if err != nil {
+			return fmt.Errorf("sns publish to %s: %w", s.TopicARN, err)
+		}
+	}
+	return nil
+}
+
+// EventBridgePublisher puts events on an EventBridge bus in batches.
+type EventBridgePublisher struct {
+	Client   eventbridgeiface.EventBridgeAPI
+	EventBus string
+	Source   string
+}
+
+func (e *EventBridgePublisher) Publish(ctx context.Context, events []Event) error {
+	for start := 0; start < len(events); start += maxPutEventsEntries {
+		end := start + maxPutEventsEntries
+		if end > len(events) {
+			end = len(events)
+		}
+
+		entries := make([]*eventbridge.PutEventsRequestEntry, 0, end-start)
+		for _, event := range events[start:end] {
+			detail, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &eventbridge.PutEventsRequestEntry{
+				Detail:       aws.String(string(detail)),
+				DetailType:   aws.String(DetailType),
+				EventBusName: aws.String(e.EventBus),
+				Source:       aws.String(e.Source),
+				Time:         aws.Time(event.Time),
+			})
+		}
+
+		out, err := e.Client.PutEventsWithContext(ctx, &eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("eventbridge put events on %s: %w", e.EventBus, err)
+		}
+		if failed := aws.Int64Value(out.FailedEntryCount); failed > 0 {
+			return fmt.Errorf("eventbridge rejected %d of %d events on %s", failed, len(entries), e.EventBus)
+		}
+	}
+	return nil
+}
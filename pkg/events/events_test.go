@@ -0,0 +1,232 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// fakeSNS records the published messages, or fails every publish with err.
+type fakeSNS struct {
+	snsiface.SNSAPI
+
+	err       error
+	published []*sns.PublishInput
+}
+
+func (f *fakeSNS) PublishWithContext(_ aws.Context, input *sns.PublishInput, _ ...request.Option) (*sns.PublishOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.published = append(f.published, input)
+	return &sns.PublishOutput{MessageId: aws.String(fmt.Sprint(len(f.published)))}, nil
+}
+
+// fakeEventBridge records the PutEvents calls and rejects the first failEntries entries of each.
+type fakeEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+
+	calls       []*eventbridge.PutEventsInput
+	failEntries int64
+}
+
+func (f *fakeEventBridge) PutEventsWithContext(_ aws.Context, input *eventbridge.PutEventsInput, _ ...request.Option) (*eventbridge.PutEventsOutput, error) {
+	f.calls = append(f.calls, input)
+	return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(f.failEntries)}, nil
+}
+
+// recordingPublisher records the published events, or fails with err.
+type recordingPublisher struct {
+	err    error
+	events []Event
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, events []Event) error {
+	r.events = append(r.events, events...)
+	return r.err
+}
+
+func testEvents(types ...string) []Event {
+	outcomes := map[string]string{
+		TypeCopied:   "copied",
+		TypeDeferred: "deferred",
+		TypeDryRun:   "dry-run",
+		TypeFailed:   "failed",
+		TypeMutated:  "updated",
+		TypeSkipped:  "skipped-cached",
+	}
+
+	events := make([]Event, 0, len(types))
+	for i, eventType := range types {
+		events = append(events, Event{
+			ECRReference:    fmt.Sprintf("123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine:3.%d", i),
+			Outcome:         outcomes[eventType],
+			RunID:           "run",
+			SourceReference: fmt.Sprintf("alpine:3.%d", i),
+			Time:            time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			Type:            eventType,
+		})
+	}
+	return events
+}
+
+func TestSNSPublisherPublishesOneMessagePerEvent(t *testing.T) {
+	client := &fakeSNS{}
+	publisher := &SNSPublisher{Client: client, TopicARN: "arn:aws:sns:us-east-1:123456789012:mirror"}
+
+	events := testEvents(TypeCopied, TypeSkipped)
+	if err := publisher.Publish(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.published) != len(events) {
+		t.Fatalf("published %d messages, want %d", len(client.published), len(events))
+	}
+
+	for i, input := range client.published {
+		event := events[i]
+		if got := aws.StringValue(input.TopicArn); got != publisher.TopicARN {
+			t.Errorf("published to %s, want %s", got, publisher.TopicARN)
+		}
+		if got := aws.StringValue(input.MessageAttributes["type"].StringValue); got != event.Type {
+			t.Errorf("type attribute = %s, want %s", got, event.Type)
+		}
+		if got := aws.StringValue(input.MessageAttributes["outcome"].StringValue); got != event.Outcome {
+			t.Errorf("outcome attribute = %s, want %s", got, event.Outcome)
+		}
+		if want := DetailType + " " + event.Type; aws.StringValue(input.Subject) != want {
+			t.Errorf("subject = %s, want %s", aws.StringValue(input.Subject), want)
+		}
+
+		var message Event
+		if err := json.Unmarshal([]byte(aws.StringValue(input.Message)), &message); err != nil {
+			t.Fatal(err)
+		}
+		if message != event {
+			t.Errorf("message = %+v, want %+v", message, event)
+		}
+	}
+}
+
+func TestSNSPublisherFails(t *testing.T) {
+	publisher := &SNSPublisher{Client: &fakeSNS{err: errors.New("AuthorizationError")}, TopicARN: "arn:aws:sns:us-east-1:123456789012:mirror"}
+
+	if err := publisher.Publish(context.Background(), testEvents(TypeCopied)); err == nil {
+		t.Error("Publish succeeded although SNS failed")
+	}
+}
+
+func TestEventBridgePublisherBatchesEvents(t *testing.T) {
+	client := &fakeEventBridge{}
+	publisher := &EventBridgePublisher{Client: client, EventBus: "mirror", Source: "ecr-mirror-sync"}
+
+	types := make([]string, 0, 23)
+	for i := 0; i < cap(types); i++ {
+		types = append(types, TypeCopied)
+	}
+	events := testEvents(types...)
+	if err := publisher.Publish(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	i := 0
+	for _, call := range client.calls {
+		sizes = append(sizes, len(call.Entries))
+		for _, entry := range call.Entries {
+			if aws.StringValue(entry.DetailType) != DetailType || aws.StringValue(entry.EventBusName) != "mirror" || aws.StringValue(entry.Source) != "ecr-mirror-sync" {
+				t.Errorf("entry %d put as %s on %s from %s", i, aws.StringValue(entry.DetailType), aws.StringValue(entry.EventBusName), aws.StringValue(entry.Source))
+			}
+
+			var detail Event
+			if err := json.Unmarshal([]byte(aws.StringValue(entry.Detail)), &detail); err != nil {
+				t.Fatal(err)
+			}
+			if detail != events[i] {
+				t.Errorf("detail %d = %+v, want %+v", i, detail, events[i])
+			}
+			i++
+		}
+	}
+	if want := []int{10, 10, 3}; fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", sizes, want)
+	}
+}
+
+func TestEventBridgePublisherFailsOnRejectedEntries(t *testing.T) {
+	publisher := &EventBridgePublisher{Client: &fakeEventBridge{failEntries: 1}, EventBus: "mirror", Source: "ecr-mirror-sync"}
+
+	if err := publisher.Publish(context.Background(), testEvents(TypeCopied, TypeFailed)); err == nil {
+		t.Error("Publish succeeded although EventBridge rejected an entry")
+	}
+}
+
+func TestFilteredPublisherOnlyForwardsTheTypes(t *testing.T) {
+	all := testEvents(TypeCopied, TypeDeferred, TypeDryRun, TypeFailed, TypeMutated, TypeSkipped)
+
+	for _, c := range []struct {
+		types []string
+		want  []string
+	}{
+		{nil, []string{TypeCopied, TypeDeferred, TypeDryRun, TypeFailed, TypeMutated, TypeSkipped}},
+		{[]string{TypeFailed, TypeDeferred}, []string{TypeDeferred, TypeFailed}},
+		{[]string{TypeDryRun}, []string{TypeDryRun}},
+	} {
+		recorder := &recordingPublisher{}
+		if err := (&filteredPublisher{publisher: recorder, types: c.types}).Publish(context.Background(), all); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, event := range recorder.events {
+			got = append(got, event.Type)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("types %v published %v, want %v", c.types, got, c.want)
+		}
+	}
+
+	recorder := &recordingPublisher{err: errors.New("not called")}
+	if err := (&filteredPublisher{publisher: recorder, types: []string{TypeFailed}}).Publish(context.Background(), testEvents(TypeCopied)); err != nil {
+		t.Errorf("Publish without matching events returned %v", err)
+	}
+}
+
+func TestMultiPublisherPublishesToAll(t *testing.T) {
+	failing := &recordingPublisher{err: errors.New("sns unavailable")}
+	working := &recordingPublisher{}
+
+	err := multiPublisher{failing, working}.Publish(context.Background(), testEvents(TypeCopied))
+	if err == nil {
+		t.Error("Publish succeeded although a publisher failed")
+	}
+	if len(working.events) != 1 {
+		t.Errorf("the second publisher got %d events after the first failed, want 1", len(working.events))
+	}
+}
+
+func TestNewPublisher(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	if publisher := NewPublisher(sess, "", "", "ecr-mirror-sync", nil); publisher != nil {
+		t.Errorf("NewPublisher without a topic or bus returned %T, want nil", publisher)
+	}
+
+	publisher, ok := NewPublisher(sess, "arn:aws:sns:us-east-1:123456789012:mirror", "mirror", "ecr-mirror-sync", []string{TypeFailed}).(*filteredPublisher)
+	if !ok {
+		t.Fatal("NewPublisher did not filter the event types")
+	}
+	if publishers, ok := publisher.publisher.(multiPublisher); !ok || len(publishers) != 2 {
+		t.Errorf("NewPublisher publishes to %#v, want the topic and the bus", publisher.publisher)
+	}
+}
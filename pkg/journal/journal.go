@@ -0,0 +1,167 @@
+// Package journal records the progress of a sync run in a local file, so an interrupted run can be resumed.
+//
+// The journal holds one json record per line: a run starts, completes entries and finishes. A run which
+// resumes an unfinished one appends to its journal, any other run starts a new journal.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Journal events.
+const (
+	EventCompleted = "completed"
+	EventFinished  = "finished"
+	EventStarted   = "started"
+)
+
+// Record is a single line of the journal.
+type Record struct {
+	At      time.Time `json:"at"`
+	Event   string    `json:"event"`
+	Key     string    `json:"key,omitempty"`
+	Outcome string    `json:"outcome,omitempty"`
+	RunID   string    `json:"runId"`
+	Total   int       `json:"total,omitempty"`
+}
+
+// Progress is what the runs recorded in a journal got done.
+type Progress struct {
+	Completed map[string]string // Outcome by key of every completed entry, the latest record wins
+	Finished  bool              // The last run finished
+	RunID     string            // The last run, empty when nothing was recorded yet
+}
+
+// Unfinished reports whether the last run recorded in the journal was interrupted.
+func (p *Progress) Unfinished() bool {
+	return p.RunID != "" && !p.Finished
+}
+
+// Load reads the progress recorded at path. A missing journal has no progress, a truncated
+// last line, e.g. written while the process was killed, is ignored.
+func Load(path string) (*Progress, error) {
+	progress := &Progress{Completed: map[string]string{}}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read journal: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+
+		switch record.Event {
+		case EventStarted:
+			progress.Finished, progress.RunID = false, record.RunID
+		case EventCompleted:
+			progress.Completed[record.Key] = record.Outcome
+		case EventFinished:
+			progress.Finished = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read journal: %w", err)
+	}
+	return progress, nil
+}
+
+// Journal appends records to a journal file, it is safe for concurrent use.
+// The methods of a nil Journal do nothing.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the journal at path. With resume the records are appended to the existing journal,
+// otherwise the journal is truncated.
+func Open(path string, resume bool) (*Journal, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal: %w", err)
+	}
+	if resume {
+		if err := terminateLastLine(path, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &Journal{file: f}, nil
+}
+
+// terminateLastLine ends a truncated last line of the journal at path, so appended records start on a line of their own.
+func terminateLastLine(path string, f *os.File) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read journal: %w", err)
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	if _, err := f.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	return nil
+}
+
+// Started records that runID started handling total entries.
+func (j *Journal) Started(runID string, total int) error {
+	return j.write(Record{Event: EventStarted, RunID: runID, Total: total})
+}
+
+// Completed records that runID handled the entry key with outcome.
+func (j *Journal) Completed(runID, key, outcome string) error {
+	return j.write(Record{Event: EventCompleted, Key: key, Outcome: outcome, RunID: runID})
+}
+
+// Finished records that runID handled all of its entries.
+func (j *Journal) Finished(runID string) error {
+	return j.write(Record{Event: EventFinished, RunID: runID})
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// write appends record as a single line, so a killed process leaves at most one truncated line behind.
+func (j *Journal) write(record Record) error {
+	if j == nil {
+		return nil
+	}
+	record.At = time.Now().UTC()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// ErrLeaseLost is returned when renewing or releasing a lease owned by someone else.
+var ErrLeaseLost = errors.New("lease lost to another owner")
+
+// DynamoDBLocker stores the lease as the item Name of a table whose partition key is the string attribute "key",
+// the same layout as the DynamoDB state store, and guards it with conditional writes.
+type DynamoDBLocker struct {
+	Client dynamodbiface.DynamoDBAPI
+	Name   string
+	Table  string
+}
+
+func NewDynamoDBLocker(sess *session.Session, table, name string) *DynamoDBLocker {
+	return &DynamoDBLocker{
+		Client: dynamodb.New(sess),
+		Name:   name,
+		Table:  table,
+	}
+}
+
+func (l *DynamoDBLocker) Acquire(ctx context.Context, owner string, ttl time.Duration) error {
+	now := time.Now()
+	_, err := l.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.Table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":       {S: aws.String(l.Name)},
+			"owner":     {S: aws.String(owner)},
+			"expiresAt": {N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #expiresAt < :now OR #owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key":       aws.String("key"),
+			"#expiresAt": aws.String("expiresAt"),
+			"#owner":     aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":   {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if isConditionFailed(err) {
+		return l.held(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("could not acquire lock %s in dynamodb table %s: %w", l.Name, l.Table, err)
+	}
+	return nil
+}
+
+func (l *DynamoDBLocker) Renew(ctx context.Context, owner string, ttl time.Duration) error {
+	_, err := l.Client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(l.Table),
+		Key:                 l.key(),
+		UpdateExpression:    aws.String("SET #expiresAt = :expiresAt"),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#expiresAt": aws.String("expiresAt"),
+			"#owner":     aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":expiresAt": {N: aws.String(strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))},
+			":owner":     {S: aws.String(owner)},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+func (l *DynamoDBLocker) Release(ctx context.Context, owner string) error {
+	_, err := l.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(l.Table),
+		Key:                      l.key(),
+		ConditionExpression:      aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{"#owner": aws.String("owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if isConditionFailed(err) {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// held returns a *HeldError describing the current holder of the lease.
+func (l *DynamoDBLocker) held(ctx context.Context) error {
+	out, err := l.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		ConsistentRead: aws.Bool(true),
+		Key:            l.key(),
+		TableName:      aws.String(l.Table),
+	})
+	if err != nil {
+		return fmt.Errorf("could not read lock %s in dynamodb table %s: %w", l.Name, l.Table, err)
+	}
+
+	owner, expiresAt := out.Item["owner"], out.Item["expiresAt"]
+	if owner == nil || expiresAt == nil {
+		return fmt.Errorf("lock %s in dynamodb table %s changed while acquiring it", l.Name, l.Table)
+	}
+
+	held := &HeldError{Owner: aws.StringValue(owner.S)}
+	if seconds, err := strconv.ParseInt(aws.StringValue(expiresAt.N), 10, 64); err == nil {
+		held.ExpiresAt = time.Unix(seconds, 0)
+	}
+	return held
+}
+
+func (l *DynamoDBLocker) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"key": {S: aws.String(l.Name)}}
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
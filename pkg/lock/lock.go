@@ -0,0 +1,124 @@
+// Package lock provides a lease based lock which keeps syncs of the same account from overlapping across clusters.
+package lock
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultName is the name of the lock when the location does not set one.
+const DefaultName = "ecr-mirror-sync"
+
+// Locker grants a lease to one owner at a time, an expired lease may be taken over by another owner.
+type Locker interface {
+	// Acquire takes the lease for owner until ttl elapses, it returns a *HeldError when another owner holds an active lease.
+	Acquire(ctx context.Context, owner string, ttl time.Duration) error
+	// Renew extends the lease of owner by ttl, it fails when owner lost the lease.
+	Renew(ctx context.Context, owner string, ttl time.Duration) error
+	// Release gives up the lease of owner.
+	Release(ctx context.Context, owner string) error
+}
+
+// HeldError is returned by Acquire when another owner holds an active lease.
+type HeldError struct {
+	ExpiresAt time.Time
+	Owner     string
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lock held by %s until %s", e.Owner, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// lease is what the DynamoDB and S3 lockers store for the current holder.
+type lease struct {
+	ExpiresAt int64  `json:"expiresAt"` // unix seconds
+	Owner     string `json:"owner"`
+}
+
+func (l lease) expiresAt() time.Time {
+	return time.Unix(l.ExpiresAt, 0)
+}
+
+// Open returns the locker at location: dynamodb://TABLE[/NAME] or s3://BUCKET/KEY.
+func Open(sess *session.Session, location string) (Locker, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "dynamodb":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid lock location %q, expected dynamodb://TABLE[/NAME]", location)
+		}
+		name := strings.TrimPrefix(u.Path, "/")
+		if name == "" {
+			name = DefaultName
+		}
+		return NewDynamoDBLocker(sess, u.Host, name), nil
+	case "s3":
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("invalid lock location %q, expected s3://BUCKET/KEY", location)
+		}
+		return NewS3Locker(sess, u.Host, key), nil
+	default:
+		return nil, fmt.Errorf("unknown lock location scheme %q, expected dynamodb:// or s3://", u.Scheme)
+	}
+}
+
+// Run calls fn while owner holds the lease of locker. The lease is renewed every third of ttl,
+// fn's context is cancelled when a renewal fails, and the lease is released once fn returns.
+// A *HeldError is returned without calling fn when another owner holds the lease.
+func Run(ctx context.Context, locker Locker, owner string, ttl time.Duration, fn func(ctx context.Context) error) error {
+	if err := locker.Acquire(ctx, owner, ttl); err != nil {
+		return err
+	}
+	logger := log.WithField("lock_owner", owner)
+	logger.Info("lock acquired")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := locker.Renew(ctx, owner, ttl); err != nil {
+					if ctx.Err() == nil {
+						logger.Errorf("could not renew lock, stopping the run: %v", err)
+						cancel()
+					}
+					return
+				}
+				logger.Debug("lock renewed")
+			}
+		}
+	}()
+
+	err := fn(ctx)
+	cancel()
+	<-renewed
+
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer releaseCancel()
+	if releaseErr := locker.Release(releaseCtx, owner); releaseErr != nil {
+		logger.Errorf("could not release lock: %v", releaseErr)
+	} else {
+		logger.Info("lock released")
+	}
+	return err
+}
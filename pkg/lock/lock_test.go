@@ -0,0 +1,129 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLocker grants the lease in memory, renewals fail once failRenew is set.
+type fakeLocker struct {
+	mu        sync.Mutex
+	expiresAt time.Time
+	failRenew bool
+	owner     string
+	released  []string
+	renewals  int
+}
+
+func (l *fakeLocker) Acquire(_ context.Context, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.owner != "" && l.owner != owner && time.Now().Before(l.expiresAt) {
+		return &HeldError{ExpiresAt: l.expiresAt, Owner: l.owner}
+	}
+	l.owner, l.expiresAt = owner, time.Now().Add(ttl)
+	return nil
+}
+
+func (l *fakeLocker) Renew(_ context.Context, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failRenew || l.owner != owner {
+		return ErrLeaseLost
+	}
+	l.renewals++
+	l.expiresAt = time.Now().Add(ttl)
+	return nil
+}
+
+func (l *fakeLocker) Release(_ context.Context, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.released = append(l.released, owner)
+	if l.owner != owner {
+		return ErrLeaseLost
+	}
+	l.owner = ""
+	return nil
+}
+
+func TestRunHoldsTheLeaseAroundFn(t *testing.T) {
+	locker := &fakeLocker{}
+	fnErr := errors.New("sync failed")
+
+	err := Run(context.Background(), locker, "a", time.Minute, func(ctx context.Context) error {
+		if locker.owner != "a" {
+			t.Errorf("lease owned by %q while fn runs, want a", locker.owner)
+		}
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Errorf("Run returned %v, want the error of fn", err)
+	}
+	if locker.owner != "" || len(locker.released) != 1 {
+		t.Errorf("lease of %q released %d times, want it released once", locker.owner, len(locker.released))
+	}
+}
+
+func TestRunSkipsFnWhileHeld(t *testing.T) {
+	locker := &fakeLocker{owner: "b", expiresAt: time.Now().Add(time.Minute)}
+
+	called := false
+	err := Run(context.Background(), locker, "a", time.Minute, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+
+	var held *HeldError
+	if !errors.As(err, &held) || held.Owner != "b" {
+		t.Fatalf("Run returned %v, want a *HeldError of b", err)
+	}
+	if called {
+		t.Error("fn ran while another owner held the lease")
+	}
+	if len(locker.released) != 0 {
+		t.Error("the lease of another owner was released")
+	}
+}
+
+func TestRunTakesOverAnExpiredLease(t *testing.T) {
+	locker := &fakeLocker{owner: "b", expiresAt: time.Now().Add(-time.Second)}
+
+	if err := Run(context.Background(), locker, "a", time.Minute, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunRenewsAndCancelsWhenTheLeaseIsLost(t *testing.T) {
+	locker := &fakeLocker{}
+	ttl := 30 * time.Millisecond
+
+	err := Run(context.Background(), locker, "a", ttl, func(ctx context.Context) error {
+		time.Sleep(3 * ttl / 2)
+
+		locker.mu.Lock()
+		renewals := locker.renewals
+		locker.failRenew = true
+		locker.mu.Unlock()
+		if renewals == 0 {
+			t.Error("the lease was not renewed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			t.Error("fn was not canceled after the lease was lost")
+			return nil
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run returned %v, want context.Canceled", err)
+	}
+}
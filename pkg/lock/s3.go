@@ -0,0 +1,176 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Locker stores the lease as a json object, guarded by S3 conditional requests: If-None-Match when the object
+// does not exist and If-Match on its ETag when taking over, renewing or releasing a lease.
+type S3Locker struct {
+	Bucket string
+	Client s3iface.S3API
+	Key    string
+
+	mu   sync.Mutex
+	etag string // ETag of the lease written by this locker
+}
+
+func NewS3Locker(sess *session.Session, bucket, key string) *S3Locker {
+	return &S3Locker{
+		Bucket: bucket,
+		Client: s3.New(sess),
+		Key:    key,
+	}
+}
+
+func (l *S3Locker) Acquire(ctx context.Context, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, etag, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Owner != owner && time.Now().Before(current.expiresAt()) {
+		return &HeldError{ExpiresAt: current.expiresAt(), Owner: current.Owner}
+	}
+
+	condition := ifMatch(etag)
+	if current == nil {
+		condition = ifNoneMatch()
+	}
+	if err := l.write(ctx, owner, ttl, condition); err != nil {
+		if isPreconditionFailed(err) {
+			return l.heldAfterRace(ctx)
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *S3Locker) Renew(ctx context.Context, owner string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.write(ctx, owner, ttl, ifMatch(l.etag)); err != nil {
+		if isPreconditionFailed(err) {
+			return ErrLeaseLost
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *S3Locker) Release(ctx context.Context, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, etag, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Owner != owner || etag != l.etag {
+		return ErrLeaseLost
+	}
+
+	// Another owner may take the lease over between the read and the delete, it is only deleted while unchanged.
+	_, err = l.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(l.Bucket),
+		Key:    aws.String(l.Key),
+	}, ifMatch(etag))
+	if isPreconditionFailed(err) {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return fmt.Errorf("could not release lock s3://%s/%s: %w", l.Bucket, l.Key, err)
+	}
+	l.etag = ""
+	return nil
+}
+
+// read returns the current lease and its ETag, a nil lease when there is none.
+func (l *S3Locker) read(ctx context.Context) (*lease, string, error) {
+	out, err := l.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(l.Bucket),
+		Key:    aws.String(l.Key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read lock s3://%s/%s: %w", l.Bucket, l.Key, err)
+	}
+	defer out.Body.Close()
+
+	var current lease
+	if err := json.NewDecoder(out.Body).Decode(&current); err != nil {
+		return nil, "", fmt.Errorf("could not decode lock s3://%s/%s: %w", l.Bucket, l.Key, err)
+	}
+	return &current, aws.StringValue(out.ETag), nil
+}
+
+// write stores the lease of owner under condition and remembers its ETag.
+func (l *S3Locker) write(ctx context.Context, owner string, ttl time.Duration, condition request.Option) error {
+	body, err := json.Marshal(lease{ExpiresAt: time.Now().Add(ttl).Unix(), Owner: owner})
+	if err != nil {
+		return err
+	}
+
+	out, err := l.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:        bytes.NewReader(body),
+		Bucket:      aws.String(l.Bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(l.Key),
+	}, condition)
+	if err != nil {
+		return fmt.Errorf("could not write lock s3://%s/%s: %w", l.Bucket, l.Key, err)
+	}
+	l.etag = aws.StringValue(out.ETag)
+	return nil
+}
+
+// heldAfterRace describes the owner which won a concurrent Acquire.
+func (l *S3Locker) heldAfterRace(ctx context.Context) error {
+	current, _, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("lock s3://%s/%s changed while acquiring it", l.Bucket, l.Key)
+	}
+	return &HeldError{ExpiresAt: current.expiresAt(), Owner: current.Owner}
+}
+
+func ifMatch(etag string) request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-Match", etag)
+	}
+}
+
+func ifNoneMatch() request.Option {
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+}
+
+// isPreconditionFailed reports whether a conditional write lost against another writer.
+func isPreconditionFailed(err error) bool {
+	var aerr awserr.RequestFailure
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	return aerr.StatusCode() == 412 || aerr.StatusCode() == 409
+}
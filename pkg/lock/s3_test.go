@@ -0,0 +1,166 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 keeps a single object and evaluates the If-Match and If-None-Match headers like S3.
+// beforeDelete runs before a delete is evaluated, e.g. to let another owner take the lease over.
+type fakeS3 struct {
+	s3iface.S3API
+
+	beforeDelete func()
+	body         []byte
+	etag         string
+	mu           sync.Mutex
+}
+
+func conditions(opts []request.Option) http.Header {
+	req := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return req.HTTPRequest.Header
+}
+
+func preconditionFailed() error {
+	return awserr.NewRequestFailure(awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil), http.StatusPreconditionFailed, "")
+}
+
+// check evaluates the conditions of a request against the object, the caller holds mu.
+func (f *fakeS3) check(header http.Header) error {
+	if match := header.Get("If-Match"); match != "" && match != f.etag {
+		return preconditionFailed()
+	}
+	if header.Get("If-None-Match") == "*" && f.body != nil {
+		return preconditionFailed()
+	}
+	return nil
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, _ *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.body == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(f.body)), ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(conditions(opts)); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(append(body, []byte(time.Now().String())...))
+	f.body, f.etag = body, `"`+hex.EncodeToString(sum[:])+`"`
+	return &s3.PutObjectOutput{ETag: aws.String(f.etag)}, nil
+}
+
+func (f *fakeS3) DeleteObjectWithContext(_ aws.Context, _ *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if f.beforeDelete != nil {
+		f.beforeDelete()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check(conditions(opts)); err != nil {
+		return nil, err
+	}
+	f.body, f.etag = nil, ""
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestS3LockerAcquireRenewRelease(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	a := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+	b := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+
+	if err := a.Acquire(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	var held *HeldError
+	if err := b.Acquire(ctx, "b", time.Minute); !errors.As(err, &held) || held.Owner != "a" {
+		t.Fatalf("second Acquire returned %v, want a *HeldError of a", err)
+	}
+	if err := a.Renew(ctx, "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx, "b", time.Minute); err != nil {
+		t.Fatalf("Acquire after Release: %v", err)
+	}
+}
+
+func TestS3LockerTakesOverAnExpiredLease(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	a := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+	b := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+
+	if err := a.Acquire(ctx, "a", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Acquire(ctx, "b", time.Minute); err != nil {
+		t.Fatalf("Acquire of an expired lease: %v", err)
+	}
+	if err := a.Renew(ctx, "a", time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Renew after a takeover returned %v, want ErrLeaseLost", err)
+	}
+	if err := a.Release(ctx, "a"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Release after a takeover returned %v, want ErrLeaseLost", err)
+	}
+}
+
+func TestS3LockerReleaseKeepsALeaseTakenOverMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	a := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+	b := &S3Locker{Bucket: "locks", Client: fake, Key: "sync"}
+
+	if err := a.Acquire(ctx, "a", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// b takes the expired lease over after a read it but before a deletes it.
+	fake.beforeDelete = func() {
+		fake.beforeDelete = nil
+		if err := b.Acquire(ctx, "b", time.Minute); err != nil {
+			t.Errorf("takeover: %v", err)
+		}
+	}
+	if err := a.Release(ctx, "a"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Release racing a takeover returned %v, want ErrLeaseLost", err)
+	}
+
+	var held *HeldError
+	if err := a.Acquire(ctx, "a", time.Minute); !errors.As(err, &held) || held.Owner != "b" {
+		t.Errorf("Acquire after the race returned %v, want the lease still held by b", err)
+	}
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "ecr_mirror_sync"
+
+// lastSuccessName is the name of the last success gauge, which is carried forward from the previous export.
+const lastSuccessName = namespace + "_last_success_timestamp_seconds"
+
+// readTimeout bounds reading the metrics of the previous run from the Pushgateway.
+const readTimeout = 10 * time.Second
+
+// Recorder collects the metrics of a single sync run. A nil *Recorder discards all observations.
+type Recorder struct {
+	copied          prometheus.Counter
+	copyBytes       prometheus.Histogram
+	copyDuration    prometheus.Histogram
+	failed          prometheus.Counter
+	lastRun         prometheus.Gauge
+	lastRunDuration prometheus.Gauge
+	lastSuccess     *prometheus.GaugeVec
+	registry        *prometheus.Registry
+	skipped         prometheus.Counter
+}
+
+// Image describes how a single upstream image:tag was handled.
+type Image struct {
+	Bytes      uint64
+	Copied     bool // the image was copied into ECR
+	Duration   time.Duration
+	Failed     bool
+	Repository string // ECR repository the image was mirrored to
+	Synced     bool   // the ECR image matches upstream, either copied or already up to date
+}
+
+// New returns a Recorder backed by its own registry, so only sync metrics are exported.
+func New() *Recorder {
+	r := &Recorder{
+		copied: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "images_copied_total",
+			Help:      "Number of images copied from upstream into ECR.",
+		}),
+		copyBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "copy_bytes",
+			Help:      "Blob bytes transferred per copied image.",
+			Buckets:   prometheus.ExponentialBuckets(1<<20, 4, 8), // 1MiB to 16GiB
+		}),
+		copyDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "copy_duration_seconds",
+			Help:      "Time taken to copy an image into ECR.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12), // 1s to ~34m
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "images_failed_total",
+			Help:      "Number of images which failed to mirror.",
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix time the last sync run finished.",
+		}),
+		lastRunDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_run_duration_seconds",
+			Help:      "Duration of the last sync run.",
+		}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time an ECR repository was last seen in sync with upstream.",
+		}, []string{"repository"}),
+		registry: prometheus.NewRegistry(),
+		skipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "images_skipped_total",
+			Help:      "Number of images which were not copied, e.g. because they are up to date.",
+		}),
+	}
+
+	r.registry.MustRegister(r.copied, r.copyBytes, r.copyDuration, r.failed, r.lastRun, r.lastRunDuration, r.lastSuccess, r.skipped)
+	return r
+}
+
+// ObserveImage records the outcome of a single image.
+func (r *Recorder) ObserveImage(img Image) {
+	if r == nil {
+		return
+	}
+
+	switch {
+	case img.Failed:
+		r.failed.Inc()
+	case img.Copied:
+		r.copied.Inc()
+		r.copyBytes.Observe(float64(img.Bytes))
+		r.copyDuration.Observe(img.Duration.Seconds())
+	default:
+		r.skipped.Inc()
+	}
+
+	if img.Synced {
+		r.lastSuccess.WithLabelValues(img.Repository).SetToCurrentTime()
+	}
+}
+
+// ObserveRun records the duration of a finished run.
+func (r *Recorder) ObserveRun(duration time.Duration) {
+	if r == nil {
+		return
+	}
+	r.lastRun.SetToCurrentTime()
+	r.lastRunDuration.Set(duration.Seconds())
+}
+
+// Export pushes the collected metrics to a Pushgateway and/or writes them to a node-exporter textfile.
+// Both replace the metrics of the previous run, so the last success of the repositories which this run
+// did not see in sync, e.g. because they failed, is read back from there first and exported again.
+func (r *Recorder) Export(pushgatewayURL, job, textfile string) error {
+	if r == nil {
+		return nil
+	}
+
+	previous := map[string]float64{}
+	if pushgatewayURL != "" {
+		readPushgateway(pushgatewayURL, job, previous)
+	}
+	if textfile != "" {
+		readTextfile(textfile, previous)
+	}
+	r.carryForward(previous)
+
+	if pushgatewayURL != "" {
+		if err := push.New(pushgatewayURL, job).Gatherer(r.registry).Push(); err != nil {
+			return fmt.Errorf("could not push metrics to %s: %w", pushgatewayURL, err)
+		}
+	}
+	if textfile != "" {
+		if err := prometheus.WriteToTextfile(textfile, r.registry); err != nil {
+			return fmt.Errorf("could not write metrics textfile: %w", err)
+		}
+	}
+	return nil
+}
+
+// carryForward sets the last success of the repositories in previous which this run did not record.
+func (r *Recorder) carryForward(previous map[string]float64) {
+	recorded := map[string]bool{}
+	families, _ := r.registry.Gather()
+	for _, family := range families {
+		if family.GetName() != lastSuccessName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			recorded[labelValue(metric, "repository")] = true
+		}
+	}
+
+	for repository, timestamp := range previous {
+		if !recorded[repository] {
+			r.lastSuccess.WithLabelValues(repository).Set(timestamp)
+		}
+	}
+}
+
+// readTextfile adds the last success per repository written to textfile by the previous run to previous.
+func readTextfile(textfile string, previous map[string]float64) {
+	f, err := os.Open(textfile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Warnf("could not read the previous metrics textfile, the last success of unsynced repositories is lost: %v", err)
+		return
+	}
+	defer f.Close()
+	parseLastSuccess(f, "", previous)
+}
+
+// readPushgateway adds the last success per repository pushed by the previous run of job to previous.
+func readPushgateway(pushgatewayURL, job string, previous map[string]float64) {
+	client := &http.Client{Timeout: readTimeout}
+	res, err := client.Get(strings.TrimSuffix(pushgatewayURL, "/") + "/metrics")
+	if err == nil && res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		err = fmt.Errorf("unexpected response %s", res.Status)
+	}
+	if err != nil {
+		log.Warnf("could not read the previous metrics from the pushgateway, the last success of unsynced repositories is lost: %v", err)
+		return
+	}
+	defer res.Body.Close()
+	parseLastSuccess(res.Body, job, previous)
+}
+
+// parseLastSuccess adds the last success per repository of the text exposition in to previous, the most
+// recent one wins. Only the metrics of job are read when it is set.
+func parseLastSuccess(in io.Reader, job string, previous map[string]float64) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		log.Warnf("could not parse the previous metrics, the last success of unsynced repositories is lost: %v", err)
+		return
+	}
+
+	family, ok := families[lastSuccessName]
+	if !ok {
+		return
+	}
+	for _, metric := range family.GetMetric() {
+		repository := labelValue(metric, "repository")
+		if repository == "" || (job != "" && labelValue(metric, "job") != job) {
+			continue
+		}
+		if value := metric.GetGauge().GetValue(); value > previous[repository] {
+			previous[repository] = value
+		}
+	}
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,281 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushgatewayMetrics is what the Pushgateway exposes after the previous run, including another job.
+const pushgatewayMetrics = `# TYPE ecr_mirror_sync_last_success_timestamp_seconds gauge
+ecr_mirror_sync_last_success_timestamp_seconds{instance="",job="ecr-mirror-sync",repository="external/alpine"} 1000
+ecr_mirror_sync_last_success_timestamp_seconds{instance="",job="ecr-mirror-sync",repository="external/nginx"} 2000
+ecr_mirror_sync_last_success_timestamp_seconds{instance="",job="other",repository="external/redis"} 3000
+# TYPE push_time_seconds gauge
+push_time_seconds{instance="",job="ecr-mirror-sync"} 1.6e+09
+`
+
+func observeTestRun(r *Recorder) {
+	r.ObserveImage(Image{Bytes: 4 << 20, Copied: true, Duration: 3 * time.Second, Repository: "external/alpine", Synced: true})
+	r.ObserveImage(Image{Repository: "external/busybox", Synced: true})
+	r.ObserveImage(Image{Failed: true, Repository: "external/nginx"})
+	r.ObserveRun(time.Minute)
+}
+
+func TestObserveImage(t *testing.T) {
+	r := New()
+	observeTestRun(r)
+
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"images_copied_total", testutil.ToFloat64(r.copied), 1},
+		{"images_failed_total", testutil.ToFloat64(r.failed), 1},
+		{"images_skipped_total", testutil.ToFloat64(r.skipped), 1},
+		{"last_run_duration_seconds", testutil.ToFloat64(r.lastRunDuration), 60},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if n := testutil.CollectAndCount(r.copyBytes); n != 1 {
+		t.Errorf("copy_bytes has %d series, want 1", n)
+	}
+	if n := testutil.CollectAndCount(r.lastSuccess); n != 2 {
+		t.Errorf("last_success_timestamp_seconds has %d repositories, want the 2 synced ones", n)
+	}
+	if ts := testutil.ToFloat64(r.lastSuccess.WithLabelValues("external/alpine")); time.Since(time.Unix(int64(ts), 0)) > time.Minute {
+		t.Errorf("last success of external/alpine = %v, want now", ts)
+	}
+	if ts := testutil.ToFloat64(r.lastRun); ts == 0 {
+		t.Error("last_run_timestamp_seconds was not set")
+	}
+}
+
+func TestNilRecorderDiscardsObservations(t *testing.T) {
+	var r *Recorder
+	observeTestRun(r)
+
+	if err := r.Export("http://127.0.0.1:1", "ecr-mirror-sync", filepath.Join(t.TempDir(), "sync.prom")); err != nil {
+		t.Errorf("Export of a nil Recorder returned %v", err)
+	}
+}
+
+func TestExportPushesToPushgateway(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		method string
+		path   string
+		body   []byte
+		format expfmt.Format
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if req.Method == http.MethodGet && req.URL.Path == "/metrics" {
+			io.WriteString(w, pushgatewayMetrics)
+			return
+		}
+		method, path = req.Method, req.URL.Path
+		format = expfmt.ResponseFormat(req.Header)
+		body, _ = io.ReadAll(req.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	r := New()
+	observeTestRun(r)
+	if err := r.Export(gateway.URL, "ecr-mirror-sync", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if method != http.MethodPut || path != "/metrics/job/ecr-mirror-sync" {
+		t.Errorf("pushed with %s %s, want PUT /metrics/job/ecr-mirror-sync", method, path)
+	}
+	for _, name := range []string{"ecr_mirror_sync_images_copied_total", "ecr_mirror_sync_last_success_timestamp_seconds"} {
+		if !bytes.Contains(body, []byte(name)) {
+			t.Errorf("pushed metrics are missing %s", name)
+		}
+	}
+
+	// The push replaces the group of the job, the last success of the failed repository is pushed again.
+	pushed := parseLastSuccessSeries(t, bytes.NewReader(body), format)
+	if pushed["external/nginx"] != 2000 {
+		t.Errorf("pushed the last success of the failed repository as %v, want the previous 2000", pushed["external/nginx"])
+	}
+	if time.Since(time.Unix(int64(pushed["external/alpine"]), 0)) > time.Minute {
+		t.Errorf("pushed the last success of the synced repository as %v, want now", pushed["external/alpine"])
+	}
+	if _, ok := pushed["external/redis"]; ok {
+		t.Error("pushed the last success of another job")
+	}
+}
+
+func TestExportPushesWhenThePreviousMetricsCanNotBeRead(t *testing.T) {
+	var pushes int
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		pushes++
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	r := New()
+	observeTestRun(r)
+	if err := r.Export(gateway.URL, "ecr-mirror-sync", ""); err != nil {
+		t.Fatal(err)
+	}
+	if pushes != 1 {
+		t.Errorf("pushed %d times, want once", pushes)
+	}
+}
+
+func TestExportFailsWhenPushgatewayRejects(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer gateway.Close()
+
+	textfile := filepath.Join(t.TempDir(), "sync.prom")
+	err := New().Export(gateway.URL, "ecr-mirror-sync", textfile)
+	if err == nil || !strings.Contains(err.Error(), gateway.URL) {
+		t.Errorf("Export returned %v, want an error naming the pushgateway", err)
+	}
+	if _, err := os.Stat(textfile); !os.IsNotExist(err) {
+		t.Errorf("textfile was written after the push failed: %v", err)
+	}
+}
+
+func TestExportWritesTextfile(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "sync.prom")
+
+	r := New()
+	observeTestRun(r)
+	if err := r.Export("", "ecr-mirror-sync", textfile); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"ecr_mirror_sync_images_copied_total 1",
+		"ecr_mirror_sync_images_failed_total 1",
+		"ecr_mirror_sync_images_skipped_total 1",
+		"ecr_mirror_sync_copy_bytes_count 1",
+		"ecr_mirror_sync_last_run_duration_seconds 60",
+		`ecr_mirror_sync_last_success_timestamp_seconds{repository="external/alpine"}`,
+	} {
+		if !strings.Contains(string(content), line) {
+			t.Errorf("textfile is missing %q:\n%s", line, content)
+		}
+	}
+	if strings.Contains(string(content), `repository="external/nginx"`) {
+		t.Error("textfile has a last success for the repository which never synced")
+	}
+}
+
+func TestExportCarriesTheLastSuccessForward(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "sync.prom")
+	previous := `# TYPE ecr_mirror_sync_last_success_timestamp_seconds gauge
+ecr_mirror_sync_last_success_timestamp_seconds{repository="external/alpine"} 1000
+ecr_mirror_sync_last_success_timestamp_seconds{repository="external/nginx"} 2000
+ecr_mirror_sync_last_success_timestamp_seconds{repository="external/removed"} 3000
+`
+	if err := os.WriteFile(textfile, []byte(previous), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second run only syncs busybox, the last success of the others is the one of the previous run.
+	for i, observe := range []func(r *Recorder){
+		observeTestRun,
+		func(r *Recorder) {
+			r.ObserveImage(Image{Failed: true, Repository: "external/alpine"})
+			r.ObserveImage(Image{Repository: "external/busybox", Synced: true})
+			r.ObserveRun(time.Minute)
+		},
+	} {
+		r := New()
+		observe(r)
+		if err := r.Export("", "ecr-mirror-sync", textfile); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(textfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written := parseLastSuccessSeries(t, f, expfmt.FmtText)
+		f.Close()
+
+		if len(written) != 4 {
+			t.Errorf("run %d wrote the last success of %v, want 4 repositories", i, written)
+		}
+		for _, repository := range []string{"external/alpine", "external/busybox"} {
+			if time.Since(time.Unix(int64(written[repository]), 0)) > time.Minute {
+				t.Errorf("run %d wrote the last success of %s as %v, want the first run", i, repository, written[repository])
+			}
+		}
+		if written["external/nginx"] != 2000 || written["external/removed"] != 3000 {
+			t.Errorf("run %d wrote %v, want the previous last success of the unsynced repositories", i, written)
+		}
+	}
+}
+
+func TestExportIgnoresAnInvalidTextfile(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "sync.prom")
+	if err := os.WriteFile(textfile, []byte("not { metrics"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New()
+	observeTestRun(r)
+	if err := r.Export("", "ecr-mirror-sync", textfile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(textfile)
+	if err != nil || !strings.Contains(string(content), `repository="external/alpine"`) {
+		t.Errorf("the invalid textfile was not replaced: %s %v", content, err)
+	}
+}
+
+// parseLastSuccessSeries returns the last success per repository exposed in in, encoded as format.
+func parseLastSuccessSeries(t *testing.T, in io.Reader, format expfmt.Format) map[string]float64 {
+	t.Helper()
+
+	series := map[string]float64{}
+	decoder := expfmt.NewDecoder(in, format)
+	for {
+		var family dto.MetricFamily
+		if err := decoder.Decode(&family); err == io.EOF {
+			return series
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if family.GetName() != lastSuccessName {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			series[labelValue(metric, "repository")] = metric.GetGauge().GetValue()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package mirror
+
+import (
+	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/redact"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// authRefreshMargin is how long before it expires the ECR authorization token is renewed, tokens are valid for 12 hours.
+const authRefreshMargin = 30 * time.Minute
+
+// ecrAuth holds the ECR authorization token shared by every run of a provider.
+type ecrAuth struct {
+	mu        sync.Mutex
+	expiresAt time.Time
+	session   *session.Session
+	token     string // decoded AWS:PASSWORD credentials
+}
+
+// credentials returns valid ECR credentials, renewing them when they expire within authRefreshMargin.
+func (a *ecrAuth) credentials() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Until(a.expiresAt) > authRefreshMargin {
+		return a.token, nil
+	}
+
+	data, err := options.GetECRAuthToken(a.session)
+	if err != nil {
+		return "", fmt.Errorf("could not get ECR authorization token from AWS: %w", err)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(aws.StringValue(data.AuthorizationToken))
+	if err != nil {
+		return "", fmt.Errorf("could not decode ECR authorization token: %w", err)
+	}
+	redact.Register(aws.StringValue(data.AuthorizationToken))
+	redact.RegisterCredentials(string(decoded))
+
+	a.token, a.expiresAt = string(decoded), aws.TimeValue(data.ExpiresAt)
+	return a.token, nil
+}
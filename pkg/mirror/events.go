@@ -0,0 +1,79 @@
+package mirror
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/events"
+	"ecr-mirror-sync/pkg/redact"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ecrReference returns the ECR image:tag a mirror is copied to.
+func (m MirrorRepository) ecrReference() string {
+	if _, tag := splitTag(m.ECRRespository); tag != "" {
+		return m.ECRRespository
+	}
+	return fmt.Sprintf("%s:%s", m.ECRRespository, m.UpstreamTag)
+}
+
+// splitTag splits image into its name and tag, empty without one. The tag separator is the last colon after
+// the final path component, registry hosts may carry a port.
+func splitTag(image string) (name, tag string) {
+	separator := strings.LastIndex(image, ":")
+	if separator < strings.LastIndex(image, "/") {
+		return image, ""
+	}
+	return image[:separator], image[separator+1:]
+}
+
+// sourceReference returns the upstream image:tag of a mirror.
+func (m MirrorRepository) sourceReference() string {
+	return fmt.Sprintf("%s:%s", m.UpstreamImage, m.UpstreamTag)
+}
+
+// eventType maps an Outcome to the type of its published event.
+func eventType(o Outcome) string {
+	switch {
+	case o == OutcomeCopied:
+		return events.TypeCopied
+	case o == OutcomeUpdated:
+		return events.TypeMutated
+	case o.Failed():
+		return events.TypeFailed
+	case o == OutcomeDeferred:
+		return events.TypeDeferred
+	case o == OutcomeDryRun:
+		return events.TypeDryRun
+	default:
+		return events.TypeSkipped
+	}
+}
+
+// publishEvents publishes one event per handled mirror. Failures are logged, they never fail the run.
+func (p *MirrorProvider) publishEvents(ctx context.Context, mirrorRepos []MirrorRepository) {
+	if p.Events == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	mirrorEvents := make([]events.Event, 0, len(mirrorRepos))
+	for _, mirror := range mirrorRepos {
+		event := events.Event{
+			ECRReference:    mirror.ecrReference(),
+			Error:           redact.Error(mirror.Err),
+			NewDigest:       mirror.UpstreamDigest,
+			OldDigest:       mirror.ECRDigest,
+			Outcome:         string(mirror.Outcome),
+			RunID:           p.RunID,
+			SourceReference: mirror.sourceReference(),
+			Time:            now,
+			Type:            eventType(mirror.Outcome),
+		}
+		mirrorEvents = append(mirrorEvents, event)
+	}
+
+	if err := p.Events.Publish(ctx, mirrorEvents); err != nil {
+		p.Logger().Error(err)
+	}
+}
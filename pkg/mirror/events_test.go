@@ -0,0 +1,27 @@
+package mirror
+
+import (
+	"ecr-mirror-sync/pkg/events"
+	"testing"
+)
+
+func TestEventType(t *testing.T) {
+	for outcome, want := range map[Outcome]string{
+		OutcomeCopied:      events.TypeCopied,
+		OutcomeUpdated:     events.TypeMutated,
+		OutcomeUpToDate:    events.TypeSkipped,
+		OutcomeUnverified:  events.TypeSkipped,
+		OutcomeCached:      events.TypeSkipped,
+		OutcomeResumed:     events.TypeSkipped,
+		OutcomeDeferred:    events.TypeDeferred,
+		OutcomeDryRun:      events.TypeDryRun,
+		OutcomeFailed:      events.TypeFailed,
+		OutcomeRepoMissing: events.TypeFailed,
+		OutcomeInvalid:     events.TypeFailed,
+		OutcomeTimeout:     events.TypeFailed,
+	} {
+		if got := eventType(outcome); got != want {
+			t.Errorf("eventType(%s) = %s, want %s", outcome, got, want)
+		}
+	}
+}
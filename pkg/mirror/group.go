@@ -0,0 +1,56 @@
+package mirror
+
+import (
+	"fmt"
+
+	"github.com/containers/image/v5/docker/reference"
+)
+
+// sourceGroup coordinates the mirrors of the same upstream image and tag, so the image is only pulled from
+// upstream once: the first mirror of the group copies it from upstream, the others wait for it and copy
+// from its ECR repository instead.
+type sourceGroup struct {
+	done  chan struct{} // Closed once first is handled
+	first MirrorRepository
+}
+
+// sourceGroups hands out the group of every mirror. It is not safe for concurrent use.
+type sourceGroups map[string]*sourceGroup
+
+// join returns the group of mirror and whether another mirror of the group joined it before.
+func (g sourceGroups) join(mirror MirrorRepository) (*sourceGroup, bool) {
+	key := sourceKey(mirror)
+	if group, ok := g[key]; ok {
+		return group, true
+	}
+	group := &sourceGroup{done: make(chan struct{})}
+	g[key] = group
+	return group, false
+}
+
+// finish records the outcome of the first mirror of the group and releases the others.
+func (g *sourceGroup) finish(first MirrorRepository) {
+	g.first = first
+	close(g.done)
+}
+
+// from prepares mirror to be copied from the ECR image of the first mirror of the group, once that one holds
+// the upstream image, and takes over its upstream digest, so mirror is compared without reading upstream again.
+// Otherwise mirror is handled from upstream.
+func (g *sourceGroup) from(mirror MirrorRepository) MirrorRepository {
+	if !g.first.Outcome.Verified() || g.first.UpstreamDigest == "" {
+		return mirror
+	}
+	mirror.CopiedFrom = g.first.ecrReference()
+	mirror.ETag, mirror.UpstreamDigest = g.first.ETag, g.first.UpstreamDigest
+	return mirror
+}
+
+// sourceKey identifies the upstream image and tag of mirror, alpine and docker.io/library/alpine are the same.
+func sourceKey(mirror MirrorRepository) string {
+	image := mirror.UpstreamImage
+	if named, err := reference.ParseNormalizedNamed(image); err == nil {
+		image = named.Name()
+	}
+	return fmt.Sprintf("%s:%s", image, mirror.UpstreamTag)
+}
@@ -0,0 +1,38 @@
+package mirror
+
+// resumed reports whether the interrupted run being resumed completed mirror, failed and deferred mirrors are handled again.
+func (p *MirrorProvider) resumed(mirror MirrorRepository) bool {
+	outcome, ok := p.Resumed[mirror.stateKey()]
+	return ok && !Outcome(outcome).Failed() && Outcome(outcome) != OutcomeDeferred
+}
+
+// journalStarted records the start of a run handling total mirrors. Dry runs are not journaled,
+// journal failures are logged and never fail the run.
+func (p *MirrorProvider) journalStarted(total int) {
+	if p.Options.DryRun {
+		return
+	}
+	if err := p.Journal.Started(p.RunID, total); err != nil {
+		p.Logger().Error(err)
+	}
+}
+
+// journalCompleted records the outcome of a handled mirror.
+func (p *MirrorProvider) journalCompleted(mirror MirrorRepository) {
+	if p.Options.DryRun {
+		return
+	}
+	if err := p.Journal.Completed(p.RunID, mirror.stateKey(), string(mirror.Outcome)); err != nil {
+		p.mirrorLogger(mirror).Error(err)
+	}
+}
+
+// journalFinished records that every mirror of the run was handled, a later --resume then starts over.
+func (p *MirrorProvider) journalFinished() {
+	if p.Options.DryRun {
+		return
+	}
+	if err := p.Journal.Finished(p.RunID); err != nil {
+		p.Logger().Error(err)
+	}
+}
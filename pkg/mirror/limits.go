@@ -0,0 +1,106 @@
+package mirror
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/gammazero/workerpool"
+)
+
+// dispatcher runs mirrors on a worker pool per upstream registry with a concurrency cap, and on a shared pool
+// for the others. Every mirror also takes one of the size global slots, so the caps never exceed --batch and
+// mirrors of a capped registry waiting for their turn never hold up those of other registries.
+type dispatcher struct {
+	caps    map[string]int
+	pools   map[string]*workerpool.WorkerPool
+	size    int
+	slots   chan struct{}
+	waiting sync.WaitGroup // Tasks submitted with SubmitAfter which are not queued on their pool yet
+}
+
+// newDispatcher returns a dispatcher running up to size mirrors at once, and up to caps[host] of a registry host.
+func newDispatcher(size int, caps map[string]int) (*dispatcher, error) {
+	normalized := make(map[string]int, len(caps))
+	for host, limit := range caps {
+		if limit < 1 {
+			return nil, fmt.Errorf("invalid concurrency %d of registry %s", limit, host)
+		}
+		normalized[normalizeRegistry(host)] = limit
+	}
+	return &dispatcher{
+		caps:  normalized,
+		pools: map[string]*workerpool.WorkerPool{},
+		size:  size,
+		slots: make(chan struct{}, size),
+	}, nil
+}
+
+// Workers returns the number of mirrors of registry which run at once.
+func (d *dispatcher) Workers(registry string) int {
+	if limit, ok := d.caps[registry]; ok && limit < d.size {
+		return limit
+	}
+	return d.size
+}
+
+// Submit queues task on the pool of registry, it is not safe for concurrent use.
+func (d *dispatcher) Submit(registry string, task func()) {
+	d.SubmitAfter(registry, nil, task)
+}
+
+// SubmitAfter queues task like Submit, but only once after is closed, so tasks waiting for others never hold
+// the worker or the global slot those need.
+func (d *dispatcher) SubmitAfter(registry string, after <-chan struct{}, task func()) {
+	if _, capped := d.caps[registry]; !capped {
+		registry = ""
+	}
+	pool, ok := d.pools[registry]
+	if !ok {
+		pool = workerpool.New(d.Workers(registry))
+		d.pools[registry] = pool
+	}
+	run := func() {
+		d.slots <- struct{}{}
+		defer func() { <-d.slots }()
+		task()
+	}
+	if after == nil {
+		pool.Submit(run)
+		return
+	}
+
+	d.waiting.Add(1)
+	go func() {
+		defer d.waiting.Done()
+		<-after
+		pool.Submit(run)
+	}()
+}
+
+// StopWait waits for the submitted tasks to complete.
+func (d *dispatcher) StopWait() {
+	d.waiting.Wait()
+	for _, pool := range d.pools {
+		pool.StopWait()
+	}
+}
+
+// upstreamRegistry returns the registry host an upstream image is pulled from, docker.io for Docker Hub.
+func upstreamRegistry(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return normalizeRegistry(strings.SplitN(image, "/", 2)[0])
+	}
+	return reference.Domain(named)
+}
+
+// normalizeRegistry maps the aliases of Docker Hub to docker.io.
+func normalizeRegistry(host string) string {
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "docker.io"
+	}
+	return host
+}
@@ -0,0 +1,30 @@
+package mirror
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// Structured log fields, every message about a mirror carries the same keys in text and json output.
+const (
+	LogFieldAction      = "action"
+	LogFieldDestination = "destination"
+	LogFieldDigest      = "digest"
+	LogFieldDuration    = "duration"
+	LogFieldRunID       = "run_id"
+	LogFieldSource      = "source"
+	LogFieldTag         = "tag"
+)
+
+// Logger returns a log entry carrying the run_id of p.
+func (p *MirrorProvider) Logger() *log.Entry {
+	return log.WithField(LogFieldRunID, p.RunID)
+}
+
+// mirrorLogger returns a log entry carrying the run_id of p and the source, destination and tag of mirror.
+func (p *MirrorProvider) mirrorLogger(mirror MirrorRepository) *log.Entry {
+	return p.Logger().WithFields(log.Fields{
+		LogFieldDestination: mirror.ECRRespository,
+		LogFieldSource:      mirror.UpstreamImage,
+		LogFieldTag:         mirror.UpstreamTag,
+	})
+}
@@ -0,0 +1,136 @@
+package mirror
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/tracing"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/containers/image/v5/docker/reference"
+)
+
+// validTag matches the tags ECR accepts.
+var validTag = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// describePageSize is the number of images listed per DescribeImages call, the maximum ECR allows.
+const describePageSize = 1000
+
+// ecrRegistryID matches the AWS account ID at the start of an ECR registry host.
+var ecrRegistryID = regexp.MustCompile(`^([0-9]{12})\.dkr\.ecr\.`)
+
+// ecrLookup lists the images of every ECR repository once, with paginated DescribeImages calls, instead of
+// describing every mirrored tag on its own. A repository is listed by the first worker which needs it.
+type ecrLookup struct {
+	repositories map[ecrRepositoryKey]*ecrRepository
+	session      *ecr.ECR
+}
+
+// ecrRepositoryKey identifies an ECR repository by the registry, the AWS account it belongs to, and its name.
+type ecrRepositoryKey struct {
+	name       string
+	registryID string // Empty for the registry of the session account, e.g. behind a --registry-host without an account
+}
+
+// ecrRepository holds the image digests by tag of a listed repository. Failed listings are not kept,
+// the next mirror of the repository lists it again.
+type ecrRepository struct {
+	digests map[string]string
+	mu      sync.Mutex
+}
+
+// ecrRepositoryOf returns the ECR repository a mirror is copied to, from its ECRRespository. The registry of
+// an ECR host is the account in it, that of the repository ARN when the host was overridden by --registry-host.
+func ecrRepositoryOf(mirror MirrorRepository) ecrRepositoryKey {
+	image, _ := splitTag(mirror.ECRRespository)
+
+	key := ecrRepositoryKey{name: image, registryID: mirror.ECRRegistryID}
+	if i := strings.Index(image, "/"); i > 0 {
+		host := image[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			key.name = image[i+1:]
+			if match := ecrRegistryID.FindStringSubmatch(host); match != nil {
+				key.registryID = match[1]
+			}
+		}
+	}
+	return key
+}
+
+// String returns the name of the repository, prefixed by its registry unless it is that of the session account.
+func (k ecrRepositoryKey) String() string {
+	if k.registryID == "" {
+		return k.name
+	}
+	return fmt.Sprintf("%s/%s", k.registryID, k.name)
+}
+
+// newECRLookup returns a lookup of the ECR repositories mirrorRepos are copied to.
+func newECRLookup(session *ecr.ECR, mirrorRepos []MirrorRepository) *ecrLookup {
+	lookup := &ecrLookup{repositories: map[ecrRepositoryKey]*ecrRepository{}, session: session}
+	for _, mirror := range mirrorRepos {
+		lookup.repositories[ecrRepositoryOf(mirror)] = &ecrRepository{}
+	}
+	return lookup
+}
+
+// digest returns the digest of the image tagged tag in repository. Like DescribeImages for a single tag, it fails
+// with an InvalidParameterException when tag is not a valid tag and an ImageNotFoundException when it does not exist.
+func (l *ecrLookup) digest(ctx context.Context, repository ecrRepositoryKey, tag string) (string, error) {
+	if !validTag.MatchString(tag) {
+		return "", awserr.New(ecr.ErrCodeInvalidParameterException, fmt.Sprintf("Invalid parameter at 'imageTag' failed to satisfy constraint: '%s' is not a valid tag", tag), nil)
+	}
+
+	repo, ok := l.repositories[repository]
+	if !ok {
+		return "", fmt.Errorf("repository %s was not looked up", repository)
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.digests == nil {
+		digests, err := l.describe(ctx, repository)
+		if err != nil {
+			return "", err
+		}
+		repo.digests = digests
+	}
+
+	digest, ok := repo.digests[tag]
+	if !ok {
+		return "", awserr.New(ecr.ErrCodeImageNotFoundException,
+			fmt.Sprintf("The image with imageId {imageTag:'%s'} does not exist within the repository with name '%s'", tag, repository.name), nil)
+	}
+	return digest, nil
+}
+
+// describe lists the tagged images of repository.
+func (l *ecrLookup) describe(ctx context.Context, repository ecrRepositoryKey) (digests map[string]string, err error) {
+	ctx, span := tracing.Start(ctx, "DescribeImages", tracing.RepositoryKey.String(repository.String()))
+	defer func() { tracing.End(span, err) }()
+
+	input := &ecr.DescribeImagesInput{
+		Filter:         &ecr.DescribeImagesFilter{TagStatus: aws.String(ecr.TagStatusTagged)},
+		MaxResults:     aws.Int64(describePageSize),
+		RepositoryName: aws.String(repository.name),
+	}
+	if repository.registryID != "" {
+		input.RegistryId = aws.String(repository.registryID)
+	}
+
+	digests = map[string]string{}
+	err = l.session.DescribeImagesPagesWithContext(ctx, input, func(page *ecr.DescribeImagesOutput, _ bool) bool {
+		for _, image := range page.ImageDetails {
+			for _, tag := range image.ImageTags {
+				digests[aws.StringValue(tag)] = aws.StringValue(image.ImageDigest)
+			}
+		}
+		return true
+	})
+	return digests, err
+}
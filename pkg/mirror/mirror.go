@@ -1,107 +1,322 @@
 package mirror
 
 import (
+	"context"
 	"ecr-mirror-sync/pkg/containers"
+	"ecr-mirror-sync/pkg/lock"
+	"ecr-mirror-sync/pkg/metrics"
 	"ecr-mirror-sync/pkg/options"
-	"encoding/base64"
+	"ecr-mirror-sync/pkg/ratelimit"
+	"ecr-mirror-sync/pkg/redact"
+	"ecr-mirror-sync/pkg/shutdown"
+	"ecr-mirror-sync/pkg/tracing"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/TwiN/go-color"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/containers/image/v5/manifest"
-	"github.com/gammazero/workerpool"
+	"github.com/google/uuid"
 	"github.com/jedib0t/go-pretty/table"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func New(opts *options.MirrorOptions) *MirrorProvider {
+func New(opts *options.MirrorOptions) (*MirrorProvider, error) {
 
 	if opts.Debug {
 		log.SetLevel(logrus.DebugLevel)
 	}
 
-	token, err := options.GetECRAuthToken(aws.String(opts.Region))
-	if err != nil {
-		return nil
-	}
+	awsClientSession := options.GetDefaultAwsClient(aws.String(opts.Region), &opts.Endpoints, opts.RetryOpts)
 
-	authorizationToken, err := base64.StdEncoding.DecodeString(*token)
-	if err != nil {
-		log.Error("could not get ECR authorization token from AWS : %v", err)
+	auth := &ecrAuth{session: awsClientSession}
+	if _, err := auth.credentials(); err != nil {
+		return nil, options.NewConfigError(err)
 	}
+	registerSecrets(opts)
 
 	return &MirrorProvider{
-		AWSClientSession: options.GetDefaultAwsClient(aws.String(opts.Region)),
+		AWSClientSession: awsClientSession,
 		DefaultECRRegion: aws.String(opts.Region),
-		ECRAuthToken:     authorizationToken,
 		ECRTypeFilter:    []*string{aws.String("ecr:repository")},
 		Options:          opts,
+		RunID:            uuid.NewString(),
 		UpstreamImageKey: aws.String(opts.UpstreamImageKey),
 		UpstreamTagsKey:  aws.String(opts.UpstreamTagsKey),
+		auth:             auth,
+	}, nil
+}
+
+// NewRun returns a copy of p for another run, with a fresh RunID and without a metrics recorder.
+// The copy shares the AWS session, the ECR authorization and the event publisher of p.
+func (p *MirrorProvider) NewRun() *MirrorProvider {
+	run := *p
+	run.Metrics = nil
+	run.RunID = uuid.NewString()
+	return &run
+}
+
+// registerSecrets registers the registry credentials passed on the command line for redaction.
+func registerSecrets(opts *options.MirrorOptions) {
+	images := []*options.ImageOptions{opts.SrcImage}
+	if opts.DestImage != nil {
+		images = append(images, opts.DestImage.ImageOptions)
+	}
+
+	for _, image := range images {
+		if image == nil {
+			continue
+		}
+		redact.Register(image.Password, image.RegistryToken)
+		redact.RegisterCredentials(image.CredsOption)
 	}
 }
 
-func (p *MirrorProvider) List() []MirrorRepository {
-	return p.getECRTaggedRepos()
+func (p *MirrorProvider) List(ctx context.Context) (*Result, error) {
+	start := time.Now()
+
+	mirrorRepos, err := p.getECRTaggedRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := newResult(mirrorRepos, start)
+	result.RunID = p.RunID
+	return result, nil
 }
 
-func (p *MirrorProvider) Sync() {
-	log.Info("Attempting to sync public images to private ecr repositories...")
-	p.copy(p.getECRTaggedRepos())
+// Tagged reports whether destination, an ECR repository with or without a tag, is one of the repositories tagged for mirroring.
+func (p *MirrorProvider) Tagged(ctx context.Context, destination string) (bool, error) {
+	mirrorRepos, err := p.getECRTaggedRepos(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	repository, _ := splitTag(destination)
+	for _, mirror := range mirrorRepos {
+		if mirror.ECRRespository == repository {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
-func (p *MirrorProvider) Copy(upstreamImageTag, ecrRespository string) {
+// Sync syncs every tagged repository accepted by the Filter, if any. With a Lock, a nil result and error are returned
+// without syncing when another sync holds the lease.
+func (p *MirrorProvider) Sync(ctx context.Context) (*Result, error) {
+	result, err := p.locked(ctx, func(ctx context.Context) (*Result, error) {
+		return p.syncMatching(ctx, p.Filter)
+	})
+
+	var held *lock.HeldError
+	if errors.As(err, &held) {
+		p.Logger().WithFields(log.Fields{
+			"lock_owner":      held.Owner,
+			"lock_expires_at": held.ExpiresAt.Format(time.RFC3339),
+		}).Warn("skipping sync, another sync holds the lock")
+		return nil, nil
+	}
+	return result, err
+}
 
-	if upstreamImageTag != "" && ecrRespository != "" {
-		upstream := strings.Split(upstreamImageTag, ":")
+// locked runs run while holding the Lock, if any, so syncs and copies of the same account never overlap.
+// A *lock.HeldError is returned without running it when another run holds the lease.
+func (p *MirrorProvider) locked(ctx context.Context, run func(ctx context.Context) (*Result, error)) (result *Result, err error) {
+	if p.Lock == nil {
+		return run(ctx)
+	}
 
-		mirrorRepos := []MirrorRepository{{
-			UpstreamImage:  upstream[0],
-			UpstreamTag:    upstream[1],
-			ECRRespository: ecrRespository,
-		}}
-		log.Info("Attempting to copy public image to private ecr repository...")
-		p.copy(mirrorRepos)
-	} else {
-		log.Error("upstream image tag or ecr repository missing")
+	err = lock.Run(ctx, p.Lock, p.lockOwner(), p.LockTTL, func(ctx context.Context) error {
+		var runErr error
+		result, runErr = run(ctx)
+		return runErr
+	})
+	return result, err
+}
+
+// lockOwner identifies this run as the holder of the lock.
+func (p *MirrorProvider) lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
 	}
+	return fmt.Sprintf("%s/%s", host, p.RunID)
+}
 
+// SyncMatching syncs the tagged repositories for which match returns true, e.g. those named by a registry webhook.
+// A nil match syncs every tagged repository. With a Lock, a *lock.HeldError is returned when another run holds the lease.
+func (p *MirrorProvider) SyncMatching(ctx context.Context, match func(MirrorRepository) bool) (*Result, error) {
+	return p.locked(ctx, func(ctx context.Context) (*Result, error) {
+		return p.syncMatching(ctx, match)
+	})
 }
 
-func (p *MirrorProvider) getImageDigest(mirror MirrorRepository, tag string) (string, error) {
-	log.Debugf("Get Image Digest for %s:%s...", mirror.ECRRespository, mirror.UpstreamTag)
+func (p *MirrorProvider) syncMatching(ctx context.Context, match func(MirrorRepository) bool) (result *Result, err error) {
+	p.Logger().Info("Attempting to sync public images to private ecr repositories...")
 
-	// We can not predict the output of calls to inspect since the image results coould be v1 or v2 image spec
-	// We therefore need to store the results in an interface which we unmarshal into json
-	var manifests interface{}
+	ctx, span := tracing.Start(ctx, "sync")
+	defer func() { tracing.End(span, err) }()
 
-	var digest string
-	var err error
-	var raw []byte
+	ctx, cancel := withTimeout(ctx, p.Options.TotalTimeout)
+	defer cancel()
 
-	mirrorImageFlag := fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.UpstreamImage, tag)
+	mirrorRepos, err := p.getECRTaggedRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if match != nil {
+		matching := mirrorRepos[:0]
+		for _, mirror := range mirrorRepos {
+			if match(mirror) {
+				matching = append(matching, mirror)
+			}
+		}
+		mirrorRepos = matching
+		p.Logger().Infof("Images matching the request: %d", len(mirrorRepos))
+	}
 
-	manifestOptions := &options.ManifestOptions{
+	result, err = p.copy(ctx, mirrorRepos)
+	if err != nil {
+		return result, err
+	}
+	if result.Interrupted {
+		return result, p.interrupted(ctx, "sync")
+	}
+	return result, p.checkFailures(result)
+}
+
+// Copy copies upstreamImageTag into the ECR repository ecrRespository. With a Lock, a *lock.HeldError is returned
+// when another run holds the lease.
+func (p *MirrorProvider) Copy(ctx context.Context, upstreamImageTag, ecrRespository string) (*Result, error) {
+
+	if upstreamImageTag == "" || ecrRespository == "" {
+		return nil, options.NewConfigError(errors.New("upstream image tag or ecr repository missing"))
+	}
+
+	upstreamImage, upstreamTag := splitTag(upstreamImageTag)
+	if upstreamTag == "" {
+		return nil, options.NewConfigError(fmt.Errorf("upstream image %q has no tag", upstreamImageTag))
+	}
+
+	mirrorRepos := []MirrorRepository{{
+		UpstreamImage:  upstreamImage,
+		UpstreamTag:    upstreamTag,
+		ECRRespository: ecrRespository,
+	}}
+	p.mirrorLogger(mirrorRepos[0]).Info("Attempting to copy public image to private ecr repository...")
+
+	return p.locked(ctx, func(ctx context.Context) (result *Result, err error) {
+		ctx, span := tracing.Start(ctx, "copy")
+		defer func() { tracing.End(span, err) }()
+
+		ctx, cancel := withTimeout(ctx, p.Options.TotalTimeout)
+		defer cancel()
+
+		result, err = p.copy(ctx, mirrorRepos)
+		if err != nil {
+			return result, err
+		}
+		if result.Interrupted {
+			return result, p.interrupted(ctx, "copy")
+		}
+		return result, p.checkFailures(result)
+	})
+}
+
+// CheckCredentials verifies the AWS credentials with STS and that a valid ECR authorization token can be obtained.
+func (p *MirrorProvider) CheckCredentials(ctx context.Context) error {
+	if _, err := sts.New(p.AWSClientSession).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+		return fmt.Errorf("could not validate aws credentials: %w", err)
+	}
+	_, err := p.auth.credentials()
+	return err
+}
+
+// destinationOptions returns a copy of the mirror options which pushes to ECR with credentials.
+func (p *MirrorProvider) destinationOptions(credentials string) *options.MirrorOptions {
+	opts := *p.Options
+	destImage := *opts.DestImage
+	imageOpts := *destImage.ImageOptions
+	imageOpts.CredsOption = credentials
+	destImage.ImageOptions = &imageOpts
+	opts.DestImage = &destImage
+	return &opts
+}
+
+// checkFailures applies the configured failure policy to a finished run.
+func (p *MirrorProvider) checkFailures(result *Result) error {
+	failed := false
+
+	switch p.Options.FailOn {
+	case options.FailOnNone:
+	case options.FailOnAll:
+		failed = result.Failed > 0 && result.Failed == result.Total
+	default:
+		failed = result.Failed > p.Options.MaxFailures
+	}
+
+	if failed {
+		return &FailureError{Failed: result.Failed, Total: result.Total}
+	}
+	return nil
+}
+
+// getHeadDigest returns the digest of the upstream manifest of mirror, read with a HEAD request which is not
+// counted as a Docker Hub pull. Multi-arch images answer with the digest of their manifest list.
+func (p *MirrorProvider) getHeadDigest(ctx context.Context, mirror MirrorRepository) (digest string, err error) {
+	ctx, span := tracing.Start(ctx, "getHeadDigest", tracing.SourceKey.String(mirror.UpstreamImage), tracing.TagKey.String(mirror.UpstreamTag))
+	defer func() {
+		span.SetAttributes(tracing.DigestKey.String(digest))
+		tracing.End(span, err)
+	}()
+
+	mirrorImageFlag := fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.UpstreamImage, mirror.UpstreamTag)
+	manifestDigest, err := p.manifestProvider().Digest(ctx, mirrorImageFlag)
+	return manifestDigest.String(), err
+}
+
+// manifestProvider returns the provider reading upstream manifests.
+func (p *MirrorProvider) manifestProvider() *containers.Manifest {
+	return containers.NewManifestProvider(options.ManifestOptions{
 		DoNotListTags: true,
 		Global:        p.Options.Global,
 		Image:         *p.Options.SrcImage,
 		Raw:           true,
 		RetryOpts:     p.Options.RetryOpts,
-	}
+	})
+}
+
+func (p *MirrorProvider) getImageDigest(ctx context.Context, mirror MirrorRepository, tag string) (digest string, err error) {
+	p.mirrorLogger(mirror).WithField(LogFieldTag, tag).Debug("getting upstream image digest")
 
-	ms := containers.NewManifestProvider(*manifestOptions)
-	raw, err = ms.Manifest([]string{mirrorImageFlag})
+	ctx, span := tracing.Start(ctx, "getImageDigest", tracing.SourceKey.String(mirror.UpstreamImage), tracing.TagKey.String(tag))
+	defer func() {
+		span.SetAttributes(tracing.DigestKey.String(digest))
+		tracing.End(span, err)
+	}()
+
+	// We can not predict the output of calls to inspect since the image results coould be v1 or v2 image spec
+	// We therefore need to store the results in an interface which we unmarshal into json
+	var manifests interface{}
+
+	var raw []byte
+
+	mirrorImageFlag := fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.UpstreamImage, tag)
+
+	raw, err = p.manifestProvider().Manifest(ctx, []string{mirrorImageFlag})
 
 	if err != nil {
 		return "", err
@@ -133,202 +348,331 @@ func (p *MirrorProvider) getImageDigest(mirror MirrorRepository, tag string) (st
 	return digest, err
 }
 
-func (p *MirrorProvider) copy(mirrorRepos []MirrorRepository) {
+func (p *MirrorProvider) copy(ctx context.Context, mirrorRepos []MirrorRepository) (*Result, error) {
 
-	var (
-		t    table.Writer
-		pool int
-		err  error
-	)
+	startedAt := time.Now()
 
-	ecrSession := ecr.New(p.AWSClientSession)
-	c := containers.NewCopyProvider(p.Options)
+	lookup := newECRLookup(ecr.New(p.AWSClientSession), mirrorRepos)
 
-	if p.Options.RenderTable {
-		t = table.NewWriter()
+	// The ECR token is renewed when a long running provider gets close to its expiry,
+	// each run pushes with its own copy of the destination options.
+	credentials, err := p.auth.credentials()
+	if err != nil {
+		return nil, err
 	}
+	c := containers.NewCopyProvider(p.destinationOptions(credentials))
 
-	totalProcessed := 0
-	totalSucceeded := 0
-	totalfailed := 0
+	if p.Options.WorkerPoolSize < 0 {
+		return nil, options.NewConfigError(fmt.Errorf("invalid batch size %d", p.Options.WorkerPoolSize))
+	}
 
-	p.Options.DestImage.CredsOption = string(p.ECRAuthToken)
+	pool := p.Options.WorkerPoolSize
+	if pool == 0 {
+		pool = len(mirrorRepos)
+	}
+	if pool < 1 {
+		pool = 1
+	}
 
-	p.Options.Global.CommandTimeout = 20 * time.Minute // Hard coded by default
+	wp, err := newDispatcher(pool, p.Options.RegistryWorkers)
+	if err != nil {
+		return nil, options.NewConfigError(err)
+	}
 
-	if p.Options.WorkerPoolSize != "" {
-		pool, err = strconv.Atoi(p.Options.WorkerPoolSize)
-		if err != nil {
-			log.Fatalf("%s", err)
+	p.Logger().Infof("Batch size for syncing images: %v", pool)
+
+	// Workers only send their outcome, the collector is the single owner of the results.
+	outcomes := make(chan MirrorRepository)
+	collected := make(chan []MirrorRepository)
+
+	go func() {
+		results := make([]MirrorRepository, 0, len(mirrorRepos))
+		for mirror := range outcomes {
+			p.Metrics.ObserveImage(metrics.Image{
+				Bytes:      mirror.BytesTransferred,
+				Copied:     mirror.Outcome.Copied(),
+				Duration:   mirror.Duration,
+				Failed:     mirror.Outcome.Failed(),
+				Repository: mirror.ECRRespository,
+				Synced:     mirror.Outcome.Verified(),
+			})
+			results = append(results, mirror)
 		}
-	} else {
-		pool = len(mirrorRepos)
-	}
+		collected <- results
+	}()
+
+	// Once ctx is canceled no further mirror is started, those in flight get the shutdown grace period to finish
+	// and the state, events and summary of the handled mirrors are still recorded.
+	workCtx, cancelWork := shutdown.WithGracePeriod(ctx, p.Options.ShutdownGrace)
+	defer cancelWork()
 
-	wp := workerpool.New(pool)
+	previous := p.loadState(ctx)
+	p.journalStarted(len(mirrorRepos))
 
-	log.Infof("Batch size for syncing images: %v", pool)
+	// Docker Hub only allows a limited number of pulls, changed images are synced first and the rest is deferred once they are spent.
+	budget := p.dockerHubBudget(ctx, mirrorRepos)
+	if budget != nil {
+		p.prioritizeChanged(ctx, mirrorRepos, previous, wp.Workers("docker.io"))
+	}
+
+	groups := sourceGroups{}
 
 	for _, mirror := range mirrorRepos {
 
 		mirror := mirror
 
-		wp.Submit(func() {
-
-			var (
-				ecrRespositoryFlag string
-				mirrorImageFlag    string
-				ecrRepo            string
-			)
+		entry, known := previous[mirror.stateKey()]
+		if known {
+			mirror.PreviousDigest, mirror.PreviousETag = entry.UpstreamDigest, entry.ETag
+		}
 
-			fromToFields := log.Fields{
-				"from": fmt.Sprintf("%s:%s", mirror.UpstreamImage, mirror.UpstreamTag),
-				"to":   fmt.Sprintf("%s:%s", mirror.ECRRespository, mirror.UpstreamTag),
+		switch {
+		case p.resumed(mirror):
+			mirror.Outcome = OutcomeResumed
+		case known && p.fresh(entry):
+			mirror.ECRDigest, mirror.UpstreamDigest, mirror.Outcome = entry.ECRDigest, entry.UpstreamDigest, OutcomeCached
+			p.journalCompleted(mirror)
+		case ctx.Err() != nil:
+			continue
+		default:
+			// Mirrors of an upstream image which another mirror handles first wait for it and copy its ECR image.
+			group, following := groups.join(mirror)
+			var after <-chan struct{}
+			if following {
+				after = group.done
 			}
 
-			if p.Options.MirrorRepoPrefix != "" {
-				ecrRepo = fmt.Sprintf("%s/%s", p.Options.MirrorRepoPrefix, mirror.UpstreamImage)
-			} else {
-				ecrRepo = mirror.UpstreamImage
-			}
+			wp.SubmitAfter(upstreamRegistry(mirror.UpstreamImage), after, func() {
+				if !following {
+					defer func() { group.finish(mirror) }()
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if following {
+					mirror = group.from(mirror)
+				}
+				start := time.Now()
+				mirror = p.mirrorImage(workCtx, lookup, c, budget, mirror)
+				mirror.Duration = time.Since(start)
+				p.logOutcome(mirror)
+				p.journalCompleted(mirror)
+				outcomes <- mirror
+			})
+			continue
+		}
 
-			imageTagFilter := &ecr.ImageIdentifier{
-				ImageTag: &mirror.UpstreamTag,
-			}
-			input := &ecr.DescribeImagesInput{
-				RepositoryName: &ecrRepo,
-				ImageIds:       []*ecr.ImageIdentifier{imageTagFilter},
-			}
+		p.logOutcome(mirror)
+		outcomes <- mirror
+	}
+	wp.StopWait()
+	close(outcomes)
 
-			// check if image tag was provided for in mirror.ECRRespository
-			if !strings.Contains(mirror.ECRRespository, ":") {
-				ecrRespositoryFlag = fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.ECRRespository, mirror.UpstreamTag)
-			} else {
-				ecrRespositoryFlag = fmt.Sprintf("%s://%s", options.RemoteTransport, mirror.ECRRespository)
-			}
+	results := <-collected
+	sortRepositories(results)
 
-			mirrorImageFlag = fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.UpstreamImage, mirror.UpstreamTag)
+	interrupted := len(results) < len(mirrorRepos)
+	if interrupted {
+		p.Logger().Warnf("interrupted, %d of %d images were not handled", len(mirrorRepos)-len(results), len(mirrorRepos))
+	} else {
+		p.journalFinished()
+	}
 
-			image, err := ecrSession.DescribeImages(input)
+	p.saveState(workCtx, previous, results)
 
-			if err != nil {
-				if aerr, ok := err.(awserr.Error); ok {
-					switch aerr.Code() {
+	p.publishEvents(workCtx, results)
 
-					case ecr.ErrCodeInvalidParameterException:
-						log.WithFields(fromToFields).Error("%s:%s: %s", mirror.ECRRespository, mirror.UpstreamTag, aerr.Message())
-						log.WithFields(fromToFields).Error("%s:%s: Will not mirror image", mirror.ECRRespository, mirror.UpstreamTag)
-						mirror.Status = color.Ize(color.Red, fmt.Sprintf("Will not mirror image: %s", err.Error()))
-					case ecr.ErrCodeRepositoryNotFoundException:
-						log.WithFields(fromToFields).Error("%s:%s: %s", mirror.ECRRespository, mirror.UpstreamTag, aerr.Message())
-						mirror.Status = color.Ize(color.Red, fmt.Sprintf("ecr repo does not exist: %s", err.Error()))
+	result := newResult(results, startedAt)
+	result.Interrupted = interrupted
+	result.RunID = p.RunID
 
-					case ecr.ErrCodeImageNotFoundException:
-						log.WithFields(fromToFields).Infof("%s:%s: %s", mirror.ECRRespository, mirror.UpstreamTag, aerr.Message())
-						log.WithFields(fromToFields).Infof("%s:%s: Will attempt to mirror public repository", mirror.ECRRespository, mirror.UpstreamTag)
+	if p.Options.RenderTable {
+		renderResultTable(p.output(), result)
+	} else {
+		logger := p.Logger()
+		logger.Infof("Total Images: %d", result.Total)
+		logger.Infof("Total Images Processed: %d", result.Processed)
+		logger.Infof("Total Mirrors Succeeded: %d", result.Succeeded)
+		logger.Infof("Total Mirrors Failed: %d", result.Failed)
 
-						if p.Options.DryRun {
-							log.WithFields(fromToFields).Infof("Would have copied image %s", mirror.UpstreamTag)
-						} else {
-							err = c.Copy([]string{mirrorImageFlag, ecrRespositoryFlag}, os.Stdout)
+	}
 
-							if err != nil {
-								mirror.Status = color.Ize(color.Red, fmt.Sprintf("failed to mirror: %s", err.Error()))
-							} else {
-								mirror.Status = color.Ize(color.Green, "success")
+	return result, nil
+}
 
-							}
-							totalProcessed++
+// mirrorImage compares a single upstream image:tag with its ECR counterpart and copies it when needed.
+// The returned MirrorRepository carries the Outcome of the comparison and copy. Reading the upstream manifest and
+// copying each take a pull from budget, the mirror is deferred once it is spent.
+func (p *MirrorProvider) mirrorImage(ctx context.Context, lookup *ecrLookup, c *containers.Copy, budget *ratelimit.Budget, mirror MirrorRepository) MirrorRepository {
 
-						}
-					}
+	logger := p.mirrorLogger(mirror)
 
-				}
+	ecrRespositoryFlag := fmt.Sprintf("%s://%s", options.RemoteTransport, mirror.ecrReference())
+	mirrorImageFlag := fmt.Sprintf("%s://%s:%s", options.RemoteTransport, mirror.UpstreamImage, mirror.UpstreamTag)
+
+	imageAttributes := []attribute.KeyValue{
+		tracing.SourceKey.String(mirror.UpstreamImage),
+		tracing.RepositoryKey.String(mirror.ECRRespository),
+		tracing.TagKey.String(mirror.UpstreamTag),
+	}
+
+	copyImage := func(copied Outcome) MirrorRepository {
+		if mirror.CopiedFrom == "" && !takePull(ctx, budget, mirror) {
+			mirror.Outcome = OutcomeDeferred
+			return mirror
+		}
+
+		ctx, span := tracing.Start(ctx, "Copy.Copy", append(imageAttributes, tracing.DigestKey.String(mirror.UpstreamDigest), tracing.ECRDigestKey.String(mirror.ECRDigest))...)
+		ctx, cancel := withTimeout(ctx, p.copyTimeout(mirror))
+		defer cancel()
+
+		var (
+			copiedDigest digest.Digest
+			err          error
+		)
+
+		if mirror.CopiedFrom != "" {
+			logger.Infof("copying from %s, which holds the same upstream image", mirror.CopiedFrom)
+			copiedFromFlag := fmt.Sprintf("%s://%s", options.RemoteTransport, mirror.CopiedFrom)
+			mirror.BytesTransferred, copiedDigest, err = c.CopyWithinDestination(ctx, []string{copiedFromFlag, ecrRespositoryFlag}, p.output())
+		} else {
+			mirror.BytesTransferred, copiedDigest, err = c.Copy(ctx, []string{mirrorImageFlag, ecrRespositoryFlag}, p.output())
+		}
+		tracing.End(span, err)
+		switch {
+		case timedOut(ctx, err):
+			logger.WithField(LogFieldDigest, mirror.UpstreamDigest).Errorf("copy timed out after %s: %s", p.copyTimeout(mirror), err)
+			mirror.Outcome, mirror.Err = OutcomeTimeout, err
+		case err != nil:
+			logger.WithField(LogFieldDigest, mirror.UpstreamDigest).Errorf("copy failed: %s", err)
+			mirror.Outcome, mirror.Err = OutcomeFailed, err
+		default:
+			// Digests are preserved, an image copied without comparing it first has the digest of the upstream one,
+			// which mirrors of the same upstream image take over.
+			if mirror.UpstreamDigest == "" {
+				mirror.UpstreamDigest = copiedDigest.String()
 			}
+			mirror.Outcome = copied
+		}
+		return mirror
+	}
 
-			if mirror.Status == "" {
+	describeCtx, cancelDescribe := withTimeout(ctx, p.manifestTimeout(mirror))
+	defer cancelDescribe()
 
-				var (
-					digest string
-					err    error
-				)
+	ecrDigest, err := lookup.digest(describeCtx, ecrRepositoryOf(mirror), mirror.UpstreamTag)
 
-				if p.Options.DryRun {
-					log.WithFields(fromToFields).Infof("Would get digest for public image tag %s", mirror.UpstreamTag)
-					mirror.Status = color.Ize(color.Yellow, "Dry Run")
-				} else {
-					log.WithFields(fromToFields).Infof("Checking Digest for Upstream Image %s with Tag %s...", mirror.UpstreamImage, mirror.UpstreamTag)
-					digest, err = p.getImageDigest(mirror, mirror.UpstreamTag)
-				}
-				if err != nil {
-					log.WithFields(fromToFields).Errorf("%s:%s: %s", mirror.ECRRespository, mirror.UpstreamTag, err)
-					mirror.Status = color.Ize(color.Red, fmt.Sprintf("failed to mirror: %s", err.Error()))
-
-				} else if digest != "" {
-					if *image.ImageDetails[0].ImageDigest != digest {
-
-						log.WithFields(fromToFields).Infof("We have a diff in digest for %s:%s %s vs %s. Attempting to copy...", mirror.UpstreamImage, mirror.UpstreamTag, *image.ImageDetails[0].ImageDigest, digest)
-
-						if p.Options.DryRun {
-							log.WithFields(fromToFields).WithFields(fromToFields).Infof("Would have copied image %s", mirror.UpstreamTag)
-						} else {
-							err = c.Copy([]string{mirrorImageFlag, ecrRespositoryFlag}, os.Stdout)
-
-							if err != nil {
-								mirror.Status = color.Ize(color.Red, fmt.Sprintf("failed to mirror: %s", err.Error()))
-							} else {
-								mirror.Status = color.Ize(color.Green, "success")
-
-							}
-							totalProcessed++
-						}
-					} else {
-						log.WithFields(fromToFields).Info("ecr image digest matches upstream image")
-						mirror.Status = color.Ize(color.White, "skipping, image exists already")
-					}
-
-				} else if image != nil && !p.Options.DryRun {
-					mirror.Status = color.Ize(color.Yellow, "Could not retrieve image digest from pulbic upstream. However an Image exist in ECR, skipped")
-				}
+	if timedOut(describeCtx, err) {
+		logger.Errorf("describing the ecr image timed out after %s: %s", p.manifestTimeout(mirror), err)
+		mirror.Outcome, mirror.Err = OutcomeTimeout, err
+		return mirror
+	}
 
-			}
-			if strings.Contains(mirror.Status, "failed") {
-				totalfailed++
-				totalProcessed++
-			}
+	if err != nil {
+		mirror.Err = err
 
-			if mirror.Status == "success" {
-				totalSucceeded++
-			}
-			if p.Options.RenderTable {
-				t.AppendRows([]table.Row{
-					{mirror.UpstreamImage, mirror.ECRRespository, mirror.UpstreamTag, mirror.Status},
-				})
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			logger.Errorf("could not describe ecr image: %s", err)
+			mirror.Outcome = OutcomeFailed
+			return mirror
+		}
+
+		switch aerr.Code() {
+
+		case ecr.ErrCodeInvalidParameterException:
+			logger.Errorf("%s, will not mirror image", aerr.Message())
+			mirror.Outcome = OutcomeInvalid
+		case ecr.ErrCodeRepositoryNotFoundException:
+			logger.Error(aerr.Message())
+			mirror.Outcome = OutcomeRepoMissing
+
+		case ecr.ErrCodeImageNotFoundException:
+			logger.Infof("%s, will attempt to mirror public repository", aerr.Message())
+
+			mirror.Err = nil
+
+			if p.Options.DryRun {
+				logger.Info("would have copied image")
+				mirror.Outcome = OutcomeDryRun
+				return mirror
 			}
-		})
+			return copyImage(OutcomeCopied)
+		default:
+			logger.Error(aerr.Message())
+			mirror.Outcome = OutcomeFailed
+		}
+		return mirror
 	}
-	wp.StopWait()
 
-	if p.Options.RenderTable {
+	mirror.ECRDigest = ecrDigest
 
-		t.SetOutputMirror(os.Stdout)
-		t.AppendHeader(table.Row{"Source Image", "Destination", "Tag", "Status"})
-		t.AppendFooter(table.Row{"Total Images Processed", totalProcessed})
-		t.AppendFooter(table.Row{"Total Succeeded", totalSucceeded})
-		t.AppendFooter(table.Row{"Total Failed", totalfailed})
-		t.AppendFooter(table.Row{"Total", t.Length()})
-		t.Render()
-	} else {
-		log.Infof("Total Images: %d", len(mirrorRepos))
-		log.Infof("Total Images Processed: %d", totalProcessed)
-		log.Infof("Total Mirrors Succeeded: %d", totalSucceeded)
-		log.Infof("Total Mirrors Failed: %d", totalfailed)
+	if p.Options.DryRun {
+		logger.Info("would get digest for public image tag")
+		mirror.Outcome = OutcomeDryRun
+		return mirror
+	}
+
+	logger.Info("checking digest for upstream image...")
+	digestCtx, cancelDigest := withTimeout(ctx, p.manifestTimeout(mirror))
+	defer cancelDigest()
 
+	// The HEAD digest settles the comparison when it is the ECR digest or the one the previous run saw,
+	// the manifest is only read, counting as a Docker Hub pull, when it does not. Mirrors copied from
+	// another mirror of the same upstream image take over the digest it compared.
+	if mirror.ETag == "" && mirror.UpstreamDigest == "" {
+		if mirror.ETag, err = p.getHeadDigest(digestCtx, mirror); err != nil {
+			logger.Debugf("could not read the upstream digest with HEAD, reading the manifest: %s", err)
+		}
 	}
 
+	var digest string
+	switch {
+	case mirror.CopiedFrom != "" && mirror.UpstreamDigest != "":
+		digest = mirror.UpstreamDigest
+	case mirror.ETag != "" && mirror.ETag == mirror.ECRDigest:
+		digest = mirror.ETag
+	case mirror.ETag != "" && mirror.ETag == mirror.PreviousETag && mirror.PreviousDigest != "":
+		digest = mirror.PreviousDigest
+	default:
+		if !takePull(ctx, budget, mirror) {
+			mirror.Outcome = OutcomeDeferred
+			return mirror
+		}
+
+		digest, err = p.getImageDigest(digestCtx, mirror, mirror.UpstreamTag)
+		if timedOut(digestCtx, err) {
+			logger.Errorf("getting the upstream image digest timed out after %s: %s", p.manifestTimeout(mirror), err)
+			mirror.Outcome, mirror.Err = OutcomeTimeout, err
+			return mirror
+		}
+		if err != nil {
+			logger.Errorf("could not get upstream image digest: %s", err)
+			mirror.Outcome, mirror.Err = OutcomeFailed, err
+			return mirror
+		}
+	}
+	mirror.UpstreamDigest = digest
+	logger = logger.WithField(LogFieldDigest, digest)
+
+	switch {
+	case digest == "":
+		logger.Warn("could not retrieve image digest from public upstream, keeping the existing ecr image")
+		mirror.Outcome = OutcomeUnverified
+	case mirror.ECRDigest != digest:
+		logger.Infof("ecr digest %s differs from upstream, attempting to copy...", mirror.ECRDigest)
+		return copyImage(OutcomeUpdated)
+	default:
+		logger.Info("ecr image digest matches upstream image")
+		mirror.Outcome = OutcomeUpToDate
+	}
+	return mirror
 }
 
-func (p *MirrorProvider) getECRTaggedRepos() (mirrorRepos []MirrorRepository) {
+func (p *MirrorProvider) getECRTaggedRepos(ctx context.Context) (mirrorRepos []MirrorRepository, err error) {
+
+	ctx, span := tracing.Start(ctx, "getECRTaggedRepos")
+	defer func() { tracing.End(span, err) }()
 
 	var t table.Writer
 
@@ -337,9 +681,9 @@ func (p *MirrorProvider) getECRTaggedRepos() (mirrorRepos []MirrorRepository) {
 	}
 	resource := resourcegroupstaggingapi.New(p.AWSClientSession)
 
-	res, err := resource.GetResources(options.ECRRepofilters())
+	res, err := resource.GetResourcesWithContext(ctx, options.ECRRepofilters())
 	if err != nil {
-		log.Fatalf("failed to get resource(s): %v", err)
+		return nil, fmt.Errorf("failed to get resource(s): %w", err)
 	}
 
 	var mirrorRepo MirrorRepository
@@ -349,13 +693,26 @@ func (p *MirrorProvider) getECRTaggedRepos() (mirrorRepos []MirrorRepository) {
 		var upstreamTags []string
 		parsedARN, err := arn.Parse(*repo.ResourceARN)
 		if err != nil {
-			log.Error(err.Error())
+			p.Logger().Error(err.Error())
+			continue
 		}
 
 		re := regexp.MustCompile("^repository/(.*?)$")
 		repoName := re.FindStringSubmatch(parsedARN.Resource)
+		if repoName == nil {
+			p.Logger().Errorf("%s is not an ecr repository", *repo.ResourceARN)
+			continue
+		}
 
-		mirrorRepo.ECRRespository = fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s", parsedARN.AccountID, parsedARN.Region, repoName[1])
+		registryHost := p.Options.RegistryHost
+		if registryHost == "" {
+			registryHost = options.ECRRegistryHost(parsedARN.AccountID, parsedARN.Region)
+		}
+
+		mirrorRepo.ECRRespository = fmt.Sprintf("%s/%s", registryHost, repoName[1])
+		mirrorRepo.ECRRegistryID = parsedARN.AccountID
+
+		mirrorRepo.CopyTimeout, mirrorRepo.ManifestTimeout = 0, 0
 
 		for _, repoTag := range repo.Tags {
 
@@ -364,6 +721,10 @@ func (p *MirrorProvider) getECRTaggedRepos() (mirrorRepos []MirrorRepository) {
 				mirrorRepo.UpstreamImage = *repoTag.Value
 			case *options.UpstreamTags:
 				upstreamTags = strings.Split(strings.Replace(*repoTag.Value, "+", "*", -1), "/")
+			case *options.UpstreamCopyTimeout:
+				mirrorRepo.CopyTimeout = p.parseTimeoutTag(*repo.ResourceARN, repoTag)
+			case *options.UpstreamManifestTimeout:
+				mirrorRepo.ManifestTimeout = p.parseTimeoutTag(*repo.ResourceARN, repoTag)
 			}
 		}
 
@@ -381,13 +742,44 @@ func (p *MirrorProvider) getECRTaggedRepos() (mirrorRepos []MirrorRepository) {
 	}
 
 	if p.Options.RenderTable {
-		t.SetOutputMirror(os.Stdout)
+		t.SetOutputMirror(p.output())
 		t.AppendHeader(table.Row{"Source Image", "Destination", "Tag", "Status"})
 		t.AppendFooter(table.Row{"Total Images to Mirror", len(mirrorRepos)})
 		t.Render()
 	}
 
-	log.Infof("Total Images to Mirror: %d", len(mirrorRepos))
-	return mirrorRepos
+	p.Logger().Infof("Total Images to Mirror: %d", len(mirrorRepos))
+	return mirrorRepos, nil
+
+}
+
+// logOutcome logs the outcome of a handled mirror with its action, digest and duration.
+func (p *MirrorProvider) logOutcome(mirror MirrorRepository) {
+	logger := p.mirrorLogger(mirror).WithFields(log.Fields{
+		LogFieldAction:   mirror.Outcome,
+		LogFieldDigest:   mirror.UpstreamDigest,
+		LogFieldDuration: mirror.Duration.Seconds(),
+	})
+	switch {
+	case mirror.Outcome.Failed():
+		logger.Error("mirror failed")
+	case mirror.Outcome == OutcomeDeferred:
+		logger.Warn("deferred: rate limited, the docker hub pull budget is spent")
+	default:
+		logger.Info("mirror done")
+	}
+}
 
+// sortRepositories orders mirrors by destination, tag and source so tables and reports are deterministic.
+func sortRepositories(mirrorRepos []MirrorRepository) {
+	sort.SliceStable(mirrorRepos, func(i, j int) bool {
+		a, b := mirrorRepos[i], mirrorRepos[j]
+		if a.ECRRespository != b.ECRRespository {
+			return a.ECRRespository < b.ECRRespository
+		}
+		if a.UpstreamTag != b.UpstreamTag {
+			return a.UpstreamTag < b.UpstreamTag
+		}
+		return a.UpstreamImage < b.UpstreamImage
+	})
 }
@@ -0,0 +1,634 @@
+package mirror
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"ecr-mirror-sync/pkg/events"
+	"ecr-mirror-sync/pkg/metrics"
+	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/tracing"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testAccountID = "123456789012"
+
+// fakeRegistry is an in-memory registry serving the distribution API which containers/image pulls and pushes
+// with, including cross-repository blob mounts. It holds both the upstream images and the ECR repositories.
+type fakeRegistry struct {
+	blobs     map[digest.Digest][]byte
+	manifests map[string]map[string][]byte // Manifests by repository and tag or digest
+	mu        sync.Mutex
+	mounts    int // Blobs mounted from another repository instead of uploaded
+	repoBlobs map[string]map[digest.Digest]bool
+	started   int // Uploads started, numbers the upload IDs
+	uploads   map[string][]byte
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		blobs:     map[digest.Digest][]byte{},
+		manifests: map[string]map[string][]byte{},
+		repoBlobs: map[string]map[digest.Digest]bool{},
+		uploads:   map[string][]byte{},
+	}
+}
+
+// push stores a single layer image holding content as repository:tag and returns its manifest digest.
+func (f *fakeRegistry) push(repository, tag, content string) digest.Digest {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	tw.WriteHeader(&tar.Header{Mode: 0644, Name: "content.txt", Size: int64(len(content)), Typeflag: tar.TypeReg})
+	tw.Write([]byte(content))
+	tw.Close()
+
+	config, _ := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"config":       map[string]interface{}{},
+		"os":           "linux",
+		"rootfs":       map[string]interface{}{"diff_ids": []digest.Digest{digest.FromBytes(layer.Bytes())}, "type": "layers"},
+	})
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"config":        map[string]interface{}{"digest": digest.FromBytes(config), "mediaType": "application/vnd.docker.container.image.v1+json", "size": len(config)},
+		"layers":        []interface{}{map[string]interface{}{"digest": digest.FromBytes(layer.Bytes()), "mediaType": "application/vnd.docker.image.rootfs.diff.tar", "size": layer.Len()}},
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"schemaVersion": 2,
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, blob := range [][]byte{layer.Bytes(), config} {
+		f.blobs[digest.FromBytes(blob)] = blob
+		f.addBlob(repository, digest.FromBytes(blob))
+	}
+	return f.putManifest(repository, tag, manifest)
+}
+
+// digest returns the digest of the manifest of repository:tag, empty when there is none.
+func (f *fakeRegistry) digest(repository, tag string) digest.Digest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	manifest, ok := f.manifests[repository][tag]
+	if !ok {
+		return ""
+	}
+	return digest.FromBytes(manifest)
+}
+
+// tags returns the digests of the tagged manifests of repository, the caller holds mu.
+func (f *fakeRegistry) tags(repository string) map[string]digest.Digest {
+	tags := map[string]digest.Digest{}
+	for ref, manifest := range f.manifests[repository] {
+		if !strings.HasPrefix(ref, "sha256:") {
+			tags[ref] = digest.FromBytes(manifest)
+		}
+	}
+	return tags
+}
+
+// addBlob makes blob available in repository, the caller holds mu.
+func (f *fakeRegistry) addBlob(repository string, blob digest.Digest) {
+	if f.repoBlobs[repository] == nil {
+		f.repoBlobs[repository] = map[digest.Digest]bool{}
+	}
+	f.repoBlobs[repository][blob] = true
+}
+
+// putManifest stores manifest as repository:tag and by its digest, the caller holds mu.
+func (f *fakeRegistry) putManifest(repository, tag string, manifest []byte) digest.Digest {
+	if f.manifests[repository] == nil {
+		f.manifests[repository] = map[string][]byte{}
+	}
+	manifestDigest := digest.FromBytes(manifest)
+	f.manifests[repository][tag] = manifest
+	f.manifests[repository][manifestDigest.String()] = manifest
+	return manifestDigest
+}
+
+// splitPath splits path around the last occurrence of sep.
+func splitPath(path, sep string) (before, after string, ok bool) {
+	i := strings.LastIndex(path, sep)
+	if i < 0 {
+		return path, "", false
+	}
+	return path[:i], path[i+len(sep):], true
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	if path == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	if repository, upload, ok := splitPath(path, "/blobs/uploads/"); ok {
+		f.serveUpload(w, r, repository, upload, body)
+		return
+	}
+	if repository, ref, ok := splitPath(path, "/manifests/"); ok {
+		if r.Method == http.MethodPut {
+			manifestDigest := f.putManifest(repository, ref, body)
+			w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repository, manifestDigest))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		manifest, ok := f.manifests[repository][ref]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(manifest)))
+		if r.Method == http.MethodGet {
+			w.Write(manifest)
+		}
+		return
+	}
+	if repository, ref, ok := splitPath(path, "/blobs/"); ok {
+		blob := digest.Digest(ref)
+		if !f.repoBlobs[repository][blob] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(f.blobs[blob])))
+		w.Header().Set("Docker-Content-Digest", ref)
+		if r.Method == http.MethodGet {
+			w.Write(f.blobs[blob])
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// serveUpload starts, continues and completes blob uploads, the caller holds mu.
+func (f *fakeRegistry) serveUpload(w http.ResponseWriter, r *http.Request, repository, upload string, body []byte) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Get("mount") != "":
+		blob := digest.Digest(query.Get("mount"))
+		if f.repoBlobs[query.Get("from")][blob] {
+			f.addBlob(repository, blob)
+			f.mounts++
+			w.Header().Set("Docker-Content-Digest", blob.String())
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, blob))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		fallthrough
+	case r.Method == http.MethodPost:
+		f.started++
+		upload = fmt.Sprintf("upload-%d", f.started)
+		f.uploads[upload] = nil
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, upload))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPatch:
+		f.uploads[upload] = append(f.uploads[upload], body...)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, upload))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(f.uploads[upload])-1))
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut:
+		content := append(f.uploads[upload], body...)
+		delete(f.uploads, upload)
+		blob := digest.Digest(query.Get("digest"))
+		if digest.FromBytes(content) != blob {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.blobs[blob] = content
+		f.addBlob(repository, blob)
+		w.Header().Set("Docker-Content-Digest", blob.String())
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, blob))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeResource is an ECR repository tagged for mirroring.
+type fakeResource struct {
+	name          string
+	upstreamImage string
+	upstreamTags  string
+}
+
+// fakeAWS serves the Resource Groups Tagging API and the ECR API calls of a sync. The images of the ECR
+// repositories are those of registry, only the repositories in existing are found by DescribeImages.
+type fakeAWS struct {
+	describes map[string]int // DescribeImages calls by registry ID and repository
+	existing  map[string]bool
+	mu        sync.Mutex
+	registry  *fakeRegistry
+	resources []fakeResource
+}
+
+func (f *fakeAWS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RegistryID     string `json:"registryId"`
+		RepositoryName string `json:"repositoryName"`
+	}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	var output interface{}
+	switch target := r.Header.Get("X-Amz-Target"); target {
+	case "ResourceGroupsTaggingAPI_20170126.GetResources":
+		var mappings []interface{}
+		for _, resource := range f.resources {
+			mappings = append(mappings, map[string]interface{}{
+				"ResourceARN": fmt.Sprintf("arn:aws:ecr:us-east-1:%s:repository/%s", testAccountID, resource.name),
+				"Tags": []interface{}{
+					map[string]string{"Key": "upstream-image", "Value": resource.upstreamImage},
+					map[string]string{"Key": "upstream-tags", "Value": resource.upstreamTags},
+				},
+			})
+		}
+		output = map[string]interface{}{"ResourceTagMappingList": mappings}
+	case "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken":
+		output = map[string]interface{}{"authorizationData": []interface{}{map[string]interface{}{
+			"authorizationToken": base64.StdEncoding.EncodeToString([]byte("AWS:fake-ecr-password")),
+			"expiresAt":          time.Now().Add(12 * time.Hour).Unix(),
+		}}}
+	case "AmazonEC2ContainerRegistry_V20150921.DescribeImages":
+		f.mu.Lock()
+		f.describes[input.RegistryID+"/"+input.RepositoryName]++
+		exists := f.existing[input.RepositoryName]
+		f.mu.Unlock()
+
+		if !exists {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"__type":  "RepositoryNotFoundException",
+				"message": fmt.Sprintf("The repository with name '%s' does not exist in the registry with id '%s'", input.RepositoryName, input.RegistryID),
+			})
+			return
+		}
+
+		f.registry.mu.Lock()
+		tagsByDigest := map[digest.Digest][]string{}
+		for tag, manifestDigest := range f.registry.tags(input.RepositoryName) {
+			tagsByDigest[manifestDigest] = append(tagsByDigest[manifestDigest], tag)
+		}
+		f.registry.mu.Unlock()
+
+		var details []interface{}
+		for manifestDigest, tags := range tagsByDigest {
+			details = append(details, map[string]interface{}{"imageDigest": manifestDigest, "imageTags": tags, "repositoryName": input.RepositoryName})
+		}
+		output = map[string]interface{}{"imageDetails": details}
+	default:
+		http.Error(w, fmt.Sprintf("unexpected call %s", target), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(output)
+}
+
+// testEnv runs a fake registry and fake AWS APIs, syncs of the providers it returns push to the registry.
+type testEnv struct {
+	aws      *fakeAWS
+	certDir  string
+	endpoint string
+	host     string // Registry host of both the upstream images and the ECR repositories
+	registry *fakeRegistry
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	registry := newFakeRegistry()
+	registryServer := httptest.NewTLSServer(registry)
+	t.Cleanup(registryServer.Close)
+
+	certDir := t.TempDir()
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: registryServer.Certificate().Raw})
+	if err := os.WriteFile(filepath.Join(certDir, "ca.crt"), cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	aws := &fakeAWS{describes: map[string]int{}, existing: map[string]bool{}, registry: registry}
+	awsServer := httptest.NewServer(aws)
+	t.Cleanup(awsServer.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDTEST")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test-secret-key")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(certDir, "missing"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(certDir, "missing"))
+
+	return &testEnv{
+		aws:      aws,
+		certDir:  certDir,
+		endpoint: awsServer.URL,
+		host:     strings.TrimPrefix(registryServer.URL, "https://"),
+		registry: registry,
+	}
+}
+
+// provider returns a provider syncing into the fake registry with the sync flags in args.
+func (e *testEnv) provider(t *testing.T, args ...string) *MirrorProvider {
+	globalFlags, globalOpts := options.GlobalFlags()
+	srcFlags, srcOpts := options.ImageFlags(globalOpts, "src-", "screds")
+	destFlags, destOpts := options.ImageDestFlags(globalOpts, "dest-", "dcreds")
+	retryFlags, retryOpts := options.RetryFlags()
+	mirrorFlags, mirrorOpts := options.MirrorFlags(globalOpts, srcOpts, destOpts, retryOpts)
+
+	fs := pflag.NewFlagSet("sync", pflag.ContinueOnError)
+	for _, flags := range []*pflag.FlagSet{&globalFlags, &srcFlags, &destFlags, &retryFlags, &mirrorFlags} {
+		fs.AddFlagSet(flags)
+	}
+	err := fs.Parse(append([]string{
+		"--dockerhub-ratelimit=false",
+		"--ecr-endpoint", e.endpoint,
+		"--insecure-policy",
+		"--registry-host", e.host,
+		"--tagging-endpoint", e.endpoint,
+	}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcOpts.DockerCertPath, destOpts.DockerCertPath = e.certDir, e.certDir
+	mirrorOpts.Quiet = true
+
+	p, err := New(mirrorOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// recordingPublisher records the published events.
+type recordingPublisher struct {
+	events []events.Event
+	mu     sync.Mutex
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, published []events.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, published...)
+	return nil
+}
+
+func quietLogs(t *testing.T) {
+	out := log.StandardLogger().Out
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+}
+
+func outcomes(result *Result) map[string]Outcome {
+	byReference := map[string]Outcome{}
+	for _, mirror := range result.Repositories {
+		byReference[strings.SplitN(mirror.ecrReference(), "/", 2)[1]] = mirror.Outcome
+	}
+	return byReference
+}
+
+func TestSyncConcurrently(t *testing.T) {
+	quietLogs(t)
+	env := newTestEnv(t)
+
+	alpine317 := env.registry.push("library/alpine", "3.17", "alpine 3.17")
+	alpine318 := env.registry.push("library/alpine", "3.18", "alpine 3.18")
+	busybox := env.registry.push("library/busybox", "1.36", "busybox 1.36")
+	env.registry.push("external/alpine", "3.18", "alpine 3.18")
+	env.registry.push("external/busybox", "1.36", "busybox 1.35")
+
+	env.aws.resources = []fakeResource{
+		{"external/alpine", env.host + "/library/alpine", "3.17/3.18"},
+		{"external/busybox", env.host + "/library/busybox", "1.36"},
+		{"missing/alpine", env.host + "/library/alpine", "3.18"},
+		{"mirror/busybox", env.host + "/library/busybox", "1.36"},
+	}
+	for i := 0; i < 8; i++ {
+		env.aws.resources = append(env.aws.resources, fakeResource{fmt.Sprintf("team%d/alpine", i), env.host + "/library/alpine", "3.17/3.18"})
+	}
+	for _, resource := range env.aws.resources {
+		env.aws.existing[resource.name] = resource.name != "missing/alpine"
+	}
+
+	p := env.provider(t, "--batch", "4", "--registry-concurrency", env.host+"=3")
+	publisher := &recordingPublisher{}
+	p.Events, p.Metrics = publisher, metrics.New()
+
+	result, err := p.Sync(context.Background())
+
+	var failure *FailureError
+	if !errors.As(err, &failure) || failure.Failed != 1 {
+		for _, mirror := range result.Repositories {
+			if mirror.Err != nil {
+				t.Logf("%s: %s", mirror.ecrReference(), mirror.Err)
+			}
+		}
+		t.Fatalf("Sync returned %v, want the missing repository to fail", err)
+	}
+	want := map[string]Outcome{
+		"external/alpine:3.17":  OutcomeCopied,
+		"external/alpine:3.18":  OutcomeUpToDate,
+		"external/busybox:1.36": OutcomeUpdated,
+		"missing/alpine:3.18":   OutcomeRepoMissing,
+		"mirror/busybox:1.36":   OutcomeCopied,
+	}
+	for i := 0; i < 8; i++ {
+		want[fmt.Sprintf("team%d/alpine:3.17", i)] = OutcomeCopied
+		want[fmt.Sprintf("team%d/alpine:3.18", i)] = OutcomeCopied
+	}
+	if got := outcomes(result); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("outcomes = %v, want %v", got, want)
+	}
+	if result.Total != len(want) || result.Succeeded != len(want)-2 || result.Failed != 1 {
+		t.Errorf("result totals %d, succeeded %d and failed %d, want %d, %d and 1", result.Total, result.Succeeded, result.Failed, len(want), len(want)-2)
+	}
+
+	sorted := sort.SliceIsSorted(result.Repositories, func(i, j int) bool {
+		return result.Repositories[i].ECRRespository < result.Repositories[j].ECRRespository
+	})
+	if !sorted {
+		t.Error("the repositories of the result are not sorted")
+	}
+
+	// Every image was pulled from upstream once, the other mirrors of the same upstream image copied it from ECR.
+	for _, mirror := range result.Repositories {
+		var first string
+		switch {
+		case mirror.Outcome.Failed() || mirror.Outcome == OutcomeUpToDate:
+			continue
+		case mirror.UpstreamTag == "3.17":
+			first = "external/alpine:3.17"
+		case mirror.UpstreamTag == "3.18":
+			first = "external/alpine:3.18"
+		default:
+			first = "external/busybox:1.36"
+		}
+		if reference := strings.SplitN(mirror.ecrReference(), "/", 2)[1]; reference == first {
+			if mirror.CopiedFrom != "" {
+				t.Errorf("%s was copied from %s, want it copied from upstream", reference, mirror.CopiedFrom)
+			}
+		} else if mirror.CopiedFrom != env.host+"/"+first {
+			t.Errorf("%s was copied from %q, want %s", reference, mirror.CopiedFrom, env.host+"/"+first)
+		}
+	}
+	if env.registry.mounts == 0 {
+		t.Error("no blob was mounted across repositories")
+	}
+
+	upstream := map[string]digest.Digest{"3.17": alpine317, "3.18": alpine318, "1.36": busybox}
+	for reference, outcome := range want {
+		if outcome.Failed() {
+			continue
+		}
+		repository, tag := splitTag(reference)
+		if got := env.registry.digest(repository, tag); got != upstream[tag] {
+			t.Errorf("%s has digest %s, want the upstream digest %s", reference, got, upstream[tag])
+		}
+	}
+
+	// Every repository was described once, with the account of its ARN.
+	for repository, calls := range env.aws.describes {
+		if calls != 1 || !strings.HasPrefix(repository, testAccountID+"/") {
+			t.Errorf("DescribeImages of %s called %d times, want once with registry %s", repository, calls, testAccountID)
+		}
+	}
+	if len(env.aws.describes) != len(env.aws.resources) {
+		t.Errorf("%d repositories described, want %d", len(env.aws.describes), len(env.aws.resources))
+	}
+
+	if len(publisher.events) != len(want) {
+		t.Errorf("published %d events, want %d", len(publisher.events), len(want))
+	}
+
+	// A second run finds every image up to date.
+	result, err = env.provider(t, "--batch", "4", "--fail-on", "none").Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for reference, outcome := range outcomes(result) {
+		if outcome != OutcomeUpToDate && outcome != OutcomeRepoMissing {
+			t.Errorf("second sync of %s: %s, want %s", reference, outcome, OutcomeUpToDate)
+		}
+	}
+}
+
+// spanAttribute returns the value of the attribute key of span, empty without one.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestSyncSpans(t *testing.T) {
+	quietLogs(t)
+	env := newTestEnv(t)
+
+	// The recording provider is kept once the test is done, the global no-op provider can not be restored.
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	env.registry.push("library/alpine", "3.17", "alpine 3.17")
+	alpine318 := env.registry.push("library/alpine", "3.18", "alpine 3.18")
+	env.registry.push("external/alpine", "3.18", "alpine 3.18")
+	env.aws.resources = []fakeResource{
+		{"external/alpine", env.host + "/library/alpine", "3.17/3.18"},
+		{"missing/alpine", env.host + "/library/alpine", "3.17"},
+	}
+	env.aws.existing["external/alpine"] = true
+
+	if _, err := env.provider(t).Sync(context.Background()); err == nil {
+		t.Fatal("Sync succeeded although a repository is missing")
+	}
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	if len(spans["sync"]) != 1 {
+		t.Fatalf("recorded %d sync spans, want 1", len(spans["sync"]))
+	}
+	root := spans["sync"][0]
+	if root.Parent().IsValid() {
+		t.Error("the sync span has a parent, want it as the root")
+	}
+	if root.Status().Code != codes.Error || root.Status().Description != "1 of 3 mirrors failed" {
+		t.Errorf("sync status = %v, want the failed mirrors", root.Status())
+	}
+
+	// Every other span is a child of the sync span.
+	for name, named := range spans {
+		for _, span := range named {
+			if name != "sync" && (span.Parent().SpanID() != root.SpanContext().SpanID() || span.SpanContext().TraceID() != root.SpanContext().TraceID()) {
+				t.Errorf("%s is not a child of the sync span", name)
+			}
+		}
+	}
+
+	for name, want := range map[string]int{"getECRTaggedRepos": 1, "DescribeImages": 2, "Copy.Copy": 1, "getHeadDigest": 1} {
+		if len(spans[name]) != want {
+			t.Errorf("recorded %d %s spans, want %d", len(spans[name]), name, want)
+		}
+	}
+
+	for _, span := range spans["DescribeImages"] {
+		repository := spanAttribute(span, tracing.RepositoryKey)
+		failed := span.Status().Code == codes.Error
+		if repository != testAccountID+"/missing/alpine" && repository != testAccountID+"/external/alpine" {
+			t.Errorf("described %s, want a repository of account %s", repository, testAccountID)
+		}
+		if failed != (repository == testAccountID+"/missing/alpine") {
+			t.Errorf("DescribeImages of %s has status %v", repository, span.Status())
+		}
+	}
+
+	if len(spans["Copy.Copy"]) == 1 {
+		span := spans["Copy.Copy"][0]
+		for key, want := range map[attribute.Key]string{
+			tracing.SourceKey:     env.host + "/library/alpine",
+			tracing.RepositoryKey: env.host + "/external/alpine",
+			tracing.TagKey:        "3.17",
+		} {
+			if got := spanAttribute(span, key); got != want {
+				t.Errorf("Copy.Copy %s = %s, want %s", key, got, want)
+			}
+		}
+	}
+	if len(spans["getHeadDigest"]) == 1 {
+		span := spans["getHeadDigest"][0]
+		if tag, headDigest := spanAttribute(span, tracing.TagKey), spanAttribute(span, tracing.DigestKey); tag != "3.18" || headDigest != alpine318.String() {
+			t.Errorf("getHeadDigest read %s as %s, want 3.18 as %s", tag, headDigest, alpine318)
+		}
+	}
+}
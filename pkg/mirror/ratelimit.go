@@ -0,0 +1,100 @@
+package mirror
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/ratelimit"
+	"ecr-mirror-sync/pkg/state"
+	"sort"
+
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/gammazero/workerpool"
+)
+
+// dockerHubBudget reads the Docker Hub pull rate limit before a run pulling from Docker Hub. It returns nil,
+// which never runs out, when the run pulls nothing from Docker Hub, the limit is disabled or can not be read.
+func (p *MirrorProvider) dockerHubBudget(ctx context.Context, mirrorRepos []MirrorRepository) *ratelimit.Budget {
+	if !p.Options.DockerHubLimit || p.Options.DryRun || !anyDockerHub(mirrorRepos) {
+		return nil
+	}
+
+	// The limit of the account pulling the images applies, from --src-creds or the auth file, else the anonymous one.
+	checker := ratelimit.NewDockerHubChecker(nil)
+	if sys, err := p.Options.SrcImage.NewSystemContext(); err == nil {
+		if sys.DockerAuthConfig != nil {
+			checker.Credentials = sys.DockerAuthConfig
+		} else if credentials, err := config.GetCredentials(sys, "docker.io"); err == nil && credentials.Username != "" {
+			checker.Credentials = &credentials
+		}
+	}
+
+	budget, err := ratelimit.NewBudget(ctx, checker, p.Options.DockerHubReserve)
+	if err != nil {
+		p.Logger().Warnf("could not read the docker hub rate limit, syncing without a budget: %v", err)
+		return nil
+	}
+	if budget.Unlimited() {
+		p.Logger().Info("docker hub reports no pull rate limit")
+		return nil
+	}
+	p.Logger().Infof("docker hub rate limit leaves %d pulls to this run", budget.Available())
+	return budget
+}
+
+func anyDockerHub(mirrorRepos []MirrorRepository) bool {
+	for _, mirror := range mirrorRepos {
+		if ratelimit.IsDockerHub(mirror.UpstreamImage) {
+			return true
+		}
+	}
+	return false
+}
+
+// takePull takes a pull of mirror from budget, only images pulled from Docker Hub count against it.
+func takePull(ctx context.Context, budget *ratelimit.Budget, mirror MirrorRepository) bool {
+	return !ratelimit.IsDockerHub(mirror.UpstreamImage) || budget.Take(ctx)
+}
+
+// prioritizeChanged reads the upstream digests of the Docker Hub mirrors with HEAD requests, which are not
+// counted as pulls, and moves the mirrors whose digest changed since the previous run to the front, so
+// they are synced before the budget runs out. Mirrors whose digest can not be read count as changed.
+func (p *MirrorProvider) prioritizeChanged(ctx context.Context, mirrorRepos []MirrorRepository, previous map[string]state.Entry, workers int) {
+	changed := make([]bool, len(mirrorRepos))
+	wp := workerpool.New(workers)
+	for i := range mirrorRepos {
+		i := i
+		mirror := &mirrorRepos[i]
+		if !ratelimit.IsDockerHub(mirror.UpstreamImage) {
+			continue
+		}
+		wp.Submit(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			digestCtx, cancel := withTimeout(ctx, p.manifestTimeout(*mirror))
+			defer cancel()
+
+			etag, err := p.getHeadDigest(digestCtx, *mirror)
+			if err != nil {
+				p.mirrorLogger(*mirror).Debugf("could not read the upstream digest with HEAD: %v", err)
+			}
+			mirror.ETag = etag
+			entry, known := previous[mirror.stateKey()]
+			changed[i] = mirror.ETag == "" || !known || entry.ETag != mirror.ETag || !Outcome(entry.Outcome).Verified()
+		})
+	}
+	wp.StopWait()
+
+	order := make([]int, len(mirrorRepos))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return changed[order[a]] && !changed[order[b]]
+	})
+
+	sorted := make([]MirrorRepository, len(mirrorRepos))
+	for i, j := range order {
+		sorted[i] = mirrorRepos[j]
+	}
+	copy(mirrorRepos, sorted)
+}
@@ -0,0 +1,85 @@
+package mirror
+
+import (
+	"ecr-mirror-sync/pkg/redact"
+	"fmt"
+	"os"
+
+	"github.com/TwiN/go-color"
+	"github.com/jedib0t/go-pretty/table"
+	"golang.org/x/term"
+)
+
+// colorEnabled reports whether f is a terminal, colors are only rendered for humans.
+func colorEnabled(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// describe returns the human readable status of a mirror, colorized when colorize is set.
+func describe(mirror MirrorRepository, colorize bool) string {
+	var (
+		text  string
+		shade string
+	)
+
+	switch mirror.Outcome {
+	case OutcomeCopied, OutcomeUpdated:
+		text, shade = "success", color.Green
+	case OutcomeUpToDate:
+		text, shade = "skipping, image exists already", color.White
+	case OutcomeCached:
+		text, shade = "skipping, verified by a previous run", color.White
+	case OutcomeResumed:
+		text, shade = "skipping, handled by the interrupted run", color.White
+	case OutcomeUnverified:
+		text, shade = "Could not retrieve image digest from public upstream. However an Image exist in ECR, skipped", color.Yellow
+	case OutcomeDryRun:
+		text, shade = "Dry Run", color.Yellow
+	case OutcomeRepoMissing:
+		text, shade = "ecr repo does not exist", color.Red
+	case OutcomeInvalid:
+		text, shade = "Will not mirror image", color.Red
+	case OutcomeFailed:
+		text, shade = "failed to mirror", color.Red
+	case OutcomeTimeout:
+		text, shade = "timed out", color.Red
+	case OutcomeDeferred:
+		text, shade = "deferred: rate limited", color.Yellow
+	default:
+		return ""
+	}
+
+	if mirror.Err != nil {
+		text = fmt.Sprintf("%s: %s", text, redact.Error(mirror.Err))
+	}
+	if !colorize {
+		return text
+	}
+	return color.Ize(shade, text)
+}
+
+// output returns where copy progress and tables are written, stdout unless Options.Output is set.
+func (p *MirrorProvider) output() *os.File {
+	if p.Options.Output != nil {
+		return p.Options.Output
+	}
+	return os.Stdout
+}
+
+// renderResultTable writes the outcome of every mirror in result as a table to out.
+func renderResultTable(out *os.File, result *Result) {
+	colorize := colorEnabled(out)
+
+	t := table.NewWriter()
+	for _, mirror := range result.Repositories {
+		t.AppendRow(table.Row{mirror.UpstreamImage, mirror.ECRRespository, mirror.UpstreamTag, describe(mirror, colorize)})
+	}
+
+	t.SetOutputMirror(out)
+	t.AppendHeader(table.Row{"Source Image", "Destination", "Tag", "Status"})
+	t.AppendFooter(table.Row{"Total Images Processed", result.Processed})
+	t.AppendFooter(table.Row{"Total Succeeded", result.Succeeded})
+	t.AppendFooter(table.Row{"Total Failed", result.Failed})
+	t.AppendFooter(table.Row{"Total", t.Length()})
+	t.Render()
+}
@@ -0,0 +1,61 @@
+package mirror
+
+import (
+	"ecr-mirror-sync/pkg/redact"
+	"ecr-mirror-sync/pkg/report"
+	"ecr-mirror-sync/pkg/state"
+)
+
+// Report converts the result of a run into a report.Report for the given command.
+func (r *Result) Report(command string) *report.Report {
+	rep := &report.Report{
+		Command:     command,
+		Duration:    r.Duration,
+		Interrupted: r.Interrupted,
+		RunID:       r.RunID,
+		StartedAt:   r.StartedAt,
+		Totals: report.Totals{
+			Failed:    r.Failed,
+			Processed: r.Processed,
+			Succeeded: r.Succeeded,
+			Total:     r.Total,
+		},
+	}
+
+	for _, mirror := range r.Repositories {
+		outcome := mirror.Outcome
+		if outcome == "" {
+			outcome = OutcomeNone
+		}
+
+		rep.Records = append(rep.Records, report.Record{
+			Action:           string(outcome),
+			BytesTransferred: mirror.BytesTransferred,
+			Changed:          outcome.Copied(),
+			ChangedSinceLast: mirror.ChangedSinceLastRun(),
+			Destination:      mirror.ECRRespository,
+			Duration:         mirror.Duration,
+			ECRDigest:        mirror.ECRDigest,
+			Error:            redact.Error(mirror.Err),
+			PreviousDigest:   mirror.PreviousDigest,
+			Source:           mirror.UpstreamImage,
+			Tag:              mirror.UpstreamTag,
+			UpstreamDigest:   mirror.UpstreamDigest,
+		})
+	}
+	return rep
+}
+
+// FailedIn returns a match for the mirrors which failed or were deferred in rep, e.g. to retry them with Sync, and their number.
+func FailedIn(rep *report.Report) (func(MirrorRepository) bool, int) {
+	failed := map[string]bool{}
+	for _, record := range rep.Records {
+		if Outcome(record.Action).Failed() || Outcome(record.Action) == OutcomeDeferred {
+			failed[state.Key(record.Source, record.Destination, record.Tag)] = true
+		}
+	}
+
+	return func(mirror MirrorRepository) bool {
+		return failed[mirror.stateKey()]
+	}, len(failed)
+}
@@ -0,0 +1,97 @@
+package mirror
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/state"
+	"time"
+)
+
+// stateKey identifies the state entry of a mirror.
+func (m MirrorRepository) stateKey() string {
+	return state.Key(m.UpstreamImage, m.ECRRespository, m.UpstreamTag)
+}
+
+// ChangedSinceLastRun reports whether the upstream digest differs from the one seen by the previous run,
+// an image seen for the first time counts as changed.
+func (m MirrorRepository) ChangedSinceLastRun() bool {
+	return m.UpstreamDigest != "" && m.UpstreamDigest != m.PreviousDigest
+}
+
+// loadState returns the entries saved by previous runs. A state which can not be read is logged
+// and treated as empty, the run then checks every mirror.
+func (p *MirrorProvider) loadState(ctx context.Context) map[string]state.Entry {
+	if p.State == nil {
+		return nil
+	}
+
+	entries, err := p.State.Load(ctx)
+	if err != nil {
+		p.Logger().Errorf("could not load state, checking every mirror: %v", err)
+		return nil
+	}
+	return entries
+}
+
+// fresh reports whether entry was verified within the state TTL, so its mirror can be skipped.
+func (p *MirrorProvider) fresh(entry state.Entry) bool {
+	return p.StateTTL > 0 &&
+		Outcome(entry.Outcome).Verified() &&
+		time.Since(entry.CheckedAt) < p.StateTTL
+}
+
+// saveState records the mirrors checked by this run. Dry runs, mirrors skipped from the state or journal and deferred mirrors are not saved.
+func (p *MirrorProvider) saveState(ctx context.Context, previous map[string]state.Entry, mirrorRepos []MirrorRepository) {
+	if p.State == nil || p.Options.DryRun {
+		return
+	}
+
+	now := time.Now().UTC()
+	entries := make([]state.Entry, 0, len(mirrorRepos))
+
+	for _, mirror := range mirrorRepos {
+		if mirror.Outcome == OutcomeCached || mirror.Outcome == OutcomeResumed || mirror.Outcome == OutcomeDeferred {
+			continue
+		}
+
+		entry, ok := previous[mirror.stateKey()]
+		if !ok {
+			entry = state.Entry{
+				Destination: mirror.ECRRespository,
+				Key:         mirror.stateKey(),
+				Source:      mirror.UpstreamImage,
+				Tag:         mirror.UpstreamTag,
+			}
+		}
+
+		entry.CheckedAt, entry.Outcome, entry.RunID = now, string(mirror.Outcome), p.RunID
+		if mirror.ChangedSinceLastRun() {
+			entry.ChangedAt = &now
+		}
+		if mirror.Outcome.Copied() {
+			entry.CopiedAt = &now
+			entry.ECRDigest = mirror.UpstreamDigest
+		} else if mirror.ECRDigest != "" {
+			entry.ECRDigest = mirror.ECRDigest
+		}
+		// The ETag is only kept along the upstream digest it was seen with, a later run reuses the digest when the ETag is unchanged.
+		if mirror.UpstreamDigest != "" {
+			entry.ETag = mirror.UpstreamDigest
+			entry.UpstreamDigest = mirror.UpstreamDigest
+			if mirror.ETag != "" {
+				entry.ETag = mirror.ETag
+			}
+		}
+		entry.Record(state.Change{
+			At:             now,
+			Outcome:        string(mirror.Outcome),
+			RunID:          p.RunID,
+			UpstreamDigest: mirror.UpstreamDigest,
+		})
+
+		entries = append(entries, entry)
+	}
+
+	if err := p.State.Put(ctx, entries); err != nil {
+		p.Logger().Errorf("could not save state: %v", err)
+	}
+}
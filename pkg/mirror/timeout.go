@@ -0,0 +1,59 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+// manifestTimeout returns how long looking up the ECR and upstream digests of mirror may take.
+func (p *MirrorProvider) manifestTimeout(mirror MirrorRepository) time.Duration {
+	if mirror.ManifestTimeout > 0 {
+		return mirror.ManifestTimeout
+	}
+	return p.Options.ManifestTimeout
+}
+
+// copyTimeout returns how long copying mirror into ECR may take.
+func (p *MirrorProvider) copyTimeout(mirror MirrorRepository) time.Duration {
+	if mirror.CopyTimeout > 0 {
+		return mirror.CopyTimeout
+	}
+	return p.Options.CopyTimeout
+}
+
+// withTimeout returns a copy of ctx which expires after timeout, 0 never expires.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// timedOut reports whether err was caused by ctx expiring. The registry clients do not always wrap
+// the context error, so the context itself is checked as well.
+func timedOut(ctx context.Context, err error) bool {
+	return err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded))
+}
+
+// interrupted returns why a run of command stopped before every mirror was handled.
+func (p *MirrorProvider) interrupted(ctx context.Context, command string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s exceeded the total timeout of %s: %w", command, p.Options.TotalTimeout, ctx.Err())
+	}
+	return fmt.Errorf("%s interrupted: %w", command, ctx.Err())
+}
+
+// parseTimeoutTag parses the duration of a timeout repository tag, invalid values are logged and ignored.
+func (p *MirrorProvider) parseTimeoutTag(repositoryARN string, tag *resourcegroupstaggingapi.Tag) time.Duration {
+	timeout, err := time.ParseDuration(aws.StringValue(tag.Value))
+	if err != nil || timeout < 0 {
+		p.Logger().Errorf("ignoring tag %s of %s, %q is not a valid duration", aws.StringValue(tag.Key), repositoryARN, aws.StringValue(tag.Value))
+		return 0
+	}
+	return timeout
+}
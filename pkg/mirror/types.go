@@ -1,24 +1,139 @@
 package mirror
 
 import (
+	"ecr-mirror-sync/pkg/events"
+	"ecr-mirror-sync/pkg/journal"
+	"ecr-mirror-sync/pkg/lock"
+	"ecr-mirror-sync/pkg/metrics"
 	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/state"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
+// Outcome is the typed result of handling a single MirrorRepository.
+type Outcome string
+
+const (
+	OutcomeNone        Outcome = "none"               // the repository was not handled, e.g. by list
+	OutcomeCopied      Outcome = "copied"             // the image was missing in ECR and has been copied
+	OutcomeUpdated     Outcome = "updated"            // the upstream digest changed and the image has been copied
+	OutcomeUpToDate    Outcome = "skipped-up-to-date" // the ECR digest matches the upstream digest
+	OutcomeUnverified  Outcome = "skipped-unverified" // the upstream digest is unknown, the existing ECR image was kept
+	OutcomeCached      Outcome = "skipped-cached"     // the image was verified by a previous run within the state TTL
+	OutcomeResumed     Outcome = "skipped-resumed"    // the image was handled by the interrupted run resumed with --resume
+	OutcomeDryRun      Outcome = "dry-run"            // nothing was copied because of --dry-run
+	OutcomeFailed      Outcome = "failed"             // looking up or copying the image failed
+	OutcomeRepoMissing Outcome = "repo-missing"       // the ECR repository does not exist
+	OutcomeInvalid     Outcome = "invalid"            // ECR rejected the repository or tag
+	OutcomeTimeout     Outcome = "timeout"            // looking up or copying the image took longer than its timeout
+	OutcomeDeferred    Outcome = "deferred"           // the docker hub pull rate limit was spent, the image is left to a later run
+)
+
+// Verified reports whether the ECR image matched the upstream image after o.
+func (o Outcome) Verified() bool {
+	return o.Copied() || o == OutcomeUpToDate || o == OutcomeCached
+}
+
+// Failed reports whether o counts as a failed mirror.
+func (o Outcome) Failed() bool {
+	return o == OutcomeFailed || o == OutcomeRepoMissing || o == OutcomeInvalid || o == OutcomeTimeout
+}
+
+// Copied reports whether an image was copied into ECR.
+func (o Outcome) Copied() bool {
+	return o == OutcomeCopied || o == OutcomeUpdated
+}
+
+// Processed reports whether a copy was attempted or the mirror failed.
+func (o Outcome) Processed() bool {
+	return o.Copied() || o.Failed()
+}
+
 type MirrorRepository struct {
-	ECRRespository string
-	Status         string
-	SyncImage      bool
-	UpstreamImage  string
-	UpstreamTag    string
+	BytesTransferred uint64
+	CopiedFrom       string        // ECR image copied instead of the upstream one, another mirror of the same upstream image copied it first
+	CopyTimeout      time.Duration // Overrides --copy-timeout for the repository, 0 keeps it
+	Duration         time.Duration
+	ECRDigest        string
+	ECRRegistryID    string // AWS account of the ECR repository, from its ARN
+	ECRRespository   string
+	ETag             string        // Upstream manifest digest answered to a HEAD request, which docker hub does not count as a pull
+	Err              error         // Why the mirror failed, set alongside a failed Outcome
+	ManifestTimeout  time.Duration // Overrides --manifest-timeout for the repository, 0 keeps it
+	Outcome          Outcome
+	PreviousDigest   string // Upstream digest seen by the previous run, from the state store
+	PreviousETag     string // Upstream HEAD digest seen by the previous run, from the state store
+	SyncImage        bool
+	UpstreamDigest   string
+	UpstreamImage    string
+	UpstreamTag      string
 }
 type MirrorProvider struct {
 	AWSClientSession *session.Session
 	DefaultECRRegion *string
-	ECRAuthToken     []byte
 	ECRTypeFilter    []*string
+	Events           events.Publisher            // Optional, receives an event for every handled mirror
+	Filter           func(MirrorRepository) bool // Optional, restricts Sync to the mirrors it returns true for
+	Journal          *journal.Journal            // Optional, records the progress of Sync so an interrupted run can be resumed
+	Lock             lock.Locker                 // Optional, held around syncs and copies so those of the same account never overlap
+	LockTTL          time.Duration               // Lease duration of Lock, renewed every third of it
+	Metrics          *metrics.Recorder           // Optional, receives the outcome of every mirror
 	Options          *options.MirrorOptions
+	Resumed          map[string]string // Outcome by state key of the mirrors handled by the interrupted run being resumed
+	RunID            string            // Identifies the run in events and logs
+	State            state.Store       // Optional, remembers digests across runs
+	StateTTL         time.Duration     // Skip mirrors verified by a previous run within StateTTL, 0 never skips
 	UpstreamImageKey *string
 	UpstreamTagsKey  *string
+
+	auth *ecrAuth // ECR credentials, shared by the runs returned by NewRun
+}
+
+// Result summarises the repositories handled by a List, Sync or Copy run.
+type Result struct {
+	Duration     time.Duration
+	Failed       int
+	Interrupted  bool // The run was stopped before every repository was handled
+	Processed    int
+	Repositories []MirrorRepository
+	RunID        string
+	StartedAt    time.Time
+	Succeeded    int
+	Total        int
+}
+
+// FailureError is returned when the failed mirrors of a run exceed the configured failure policy.
+type FailureError struct {
+	Failed int
+	Total  int
+}
+
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("%d of %d mirrors failed", e.Failed, e.Total)
+}
+
+// newResult tallies the outcomes of repositories handled by a run started at startedAt.
+func newResult(repositories []MirrorRepository, startedAt time.Time) *Result {
+	result := &Result{
+		Duration:     time.Since(startedAt),
+		Repositories: repositories,
+		StartedAt:    startedAt,
+		Total:        len(repositories),
+	}
+
+	for _, mirror := range repositories {
+		if mirror.Outcome.Processed() {
+			result.Processed++
+		}
+		if mirror.Outcome.Copied() {
+			result.Succeeded++
+		}
+		if mirror.Outcome.Failed() {
+			result.Failed++
+		}
+	}
+	return result
 }
@@ -0,0 +1,297 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"ecr-mirror-sync/pkg/redact"
+	"ecr-mirror-sync/pkg/report"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Payload formats of a webhook.
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatTeams = "teams"
+)
+
+// Filters restricting when notifications are sent.
+const (
+	OnAlways  = "always"
+	OnChange  = "change"  // at least one image was copied into ECR
+	OnFailure = "failure" // at least one mirror failed
+)
+
+// DefaultTemplate renders the run summary of a notification.
+const DefaultTemplate = `ecr-mirror-sync {{ .Command }}: {{ .Totals.Succeeded }} copied, {{ .Totals.Failed }} failed of {{ .Totals.Total }} images in {{ round .Duration }}`
+
+// Webhook is a single notification target.
+type Webhook struct {
+	Format string
+	URL    string
+}
+
+// Notifier posts run summaries to webhooks.
+type Notifier struct {
+	client   *http.Client
+	on       []string
+	template *template.Template
+	webhooks []Webhook
+}
+
+// ParseWebhook parses a `[FORMAT=]URL` webhook specification, the format defaults to json. The URL is
+// registered with redact, Slack and Teams webhook URLs are credentials.
+func ParseWebhook(spec string) (Webhook, error) {
+	webhook := Webhook{Format: FormatJSON, URL: spec}
+
+	if i := strings.Index(spec, "="); i > 0 && !strings.Contains(spec[:i], "/") {
+		webhook.Format, webhook.URL = spec[:i], spec[i+1:]
+	}
+
+	switch webhook.Format {
+	case FormatJSON, FormatSlack, FormatTeams:
+	default:
+		return webhook, fmt.Errorf("unknown webhook format %q, expected one of %s, %s or %s", webhook.Format, FormatJSON, FormatSlack, FormatTeams)
+	}
+	redact.Register(webhook.URL)
+	if !strings.HasPrefix(webhook.URL, "http://") && !strings.HasPrefix(webhook.URL, "https://") {
+		return webhook, fmt.Errorf("%s webhook url must be http or https", webhook.Format)
+	}
+	return webhook, nil
+}
+
+// New returns a Notifier for webhooks, messageTemplate defaults to DefaultTemplate.
+func New(webhooks []Webhook, on []string, messageTemplate string) (*Notifier, error) {
+	for _, filter := range on {
+		switch filter {
+		case OnAlways, OnChange, OnFailure:
+		default:
+			return nil, fmt.Errorf("unknown notification filter %q, expected one of %s, %s or %s", filter, OnAlways, OnChange, OnFailure)
+		}
+	}
+
+	if messageTemplate == "" {
+		messageTemplate = DefaultTemplate
+	}
+	funcs := template.FuncMap{
+		"round": func(d time.Duration) time.Duration { return d.Round(time.Second) },
+	}
+	tmpl, err := template.New("message").Funcs(funcs).Parse(messageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification template: %w", err)
+	}
+
+	return &Notifier{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		on:       on,
+		template: tmpl,
+		webhooks: webhooks,
+	}, nil
+}
+
+// ShouldNotify reports whether r passes the notification filters.
+func (n *Notifier) ShouldNotify(r *report.Report) bool {
+	if len(n.on) == 0 {
+		return true
+	}
+	for _, filter := range n.on {
+		switch filter {
+		case OnAlways:
+			return true
+		case OnChange:
+			if len(changes(r)) > 0 {
+				return true
+			}
+		case OnFailure:
+			if len(failures(r)) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Notify posts r to every webhook when it passes the filters.
+// All webhooks are attempted, the returned error joins the ones that failed.
+func (n *Notifier) Notify(ctx context.Context, r *report.Report) error {
+	if n == nil || len(n.webhooks) == 0 || !n.ShouldNotify(r) {
+		return nil
+	}
+
+	var message strings.Builder
+	if err := n.template.Execute(&message, r); err != nil {
+		return fmt.Errorf("could not render notification: %w", err)
+	}
+
+	var errs []string
+	for _, webhook := range n.webhooks {
+		body, err := payload(webhook.Format, message.String(), r)
+		if err == nil {
+			err = n.post(ctx, webhook.URL, body)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s webhook: %v", webhook.Format, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not send notifications: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (n *Notifier) post(ctx context.Context, webhookURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// withoutURL drops the webhook url from the errors of a request, it is a credential for most webhooks.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// failures returns the records of r which failed.
+func failures(r *report.Report) []report.Record {
+	var records []report.Record
+	for _, record := range r.Records {
+		if record.Error != "" {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// changes returns the records of r which were copied into ECR.
+func changes(r *report.Report) []report.Record {
+	var records []report.Record
+	for _, record := range r.Records {
+		if record.Changed {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// describe returns a one line description of a record.
+func describe(record report.Record) string {
+	line := fmt.Sprintf("%s:%s -> %s (%s)", record.Source, record.Tag, record.Destination, record.Action)
+	if record.Error != "" {
+		line += ": " + record.Error
+	}
+	return line
+}
+
+func payload(format, message string, r *report.Report) ([]byte, error) {
+	switch format {
+	case FormatSlack:
+		return json.Marshal(slackPayload(message, r))
+	case FormatTeams:
+		return json.Marshal(teamsPayload(message, r))
+	default:
+		return json.Marshal(jsonPayload{
+			Changes:  changes(r),
+			Command:  r.Command,
+			Failures: failures(r),
+			Message:  message,
+			Totals:   r.Totals,
+		})
+	}
+}
+
+type jsonPayload struct {
+	Changes  []report.Record `json:"changes"`
+	Command  string          `json:"command"`
+	Failures []report.Record `json:"failures"`
+	Message  string          `json:"message"`
+	Totals   report.Totals   `json:"totals"`
+}
+
+type slackMessage struct {
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+	Text        string            `json:"text"`
+}
+
+type slackAttachment struct {
+	Color string `json:"color"`
+	Text  string `json:"text"`
+	Title string `json:"title"`
+}
+
+func slackPayload(message string, r *report.Report) slackMessage {
+	msg := slackMessage{Text: message}
+
+	if records := failures(r); len(records) > 0 {
+		msg.Attachments = append(msg.Attachments, slackAttachment{Color: "danger", Text: lines(records), Title: "Failed mirrors"})
+	}
+	if records := changes(r); len(records) > 0 {
+		msg.Attachments = append(msg.Attachments, slackAttachment{Color: "good", Text: lines(records), Title: "Updated mirrors"})
+	}
+	return msg
+}
+
+type teamsMessageCard struct {
+	Context    string         `json:"@context"`
+	Sections   []teamsSection `json:"sections,omitempty"`
+	Summary    string         `json:"summary"`
+	Text       string         `json:"text"`
+	ThemeColor string         `json:"themeColor"`
+	Type       string         `json:"@type"`
+}
+
+type teamsSection struct {
+	ActivityTitle string `json:"activityTitle"`
+	Text          string `json:"text"`
+}
+
+func teamsPayload(message string, r *report.Report) teamsMessageCard {
+	card := teamsMessageCard{
+		Context:    "https://schema.org/extensions",
+		Summary:    message,
+		Text:       message,
+		ThemeColor: "2EB886",
+		Type:       "MessageCard",
+	}
+
+	if records := failures(r); len(records) > 0 {
+		card.ThemeColor = "D00000"
+		card.Sections = append(card.Sections, teamsSection{ActivityTitle: "Failed mirrors", Text: lines(records)})
+	}
+	if records := changes(r); len(records) > 0 {
+		card.Sections = append(card.Sections, teamsSection{ActivityTitle: "Updated mirrors", Text: lines(records)})
+	}
+	return card
+}
+
+func lines(records []report.Record) string {
+	var b strings.Builder
+	for _, record := range records {
+		b.WriteString("- ")
+		b.WriteString(describe(record))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/redact"
+	"ecr-mirror-sync/pkg/report"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer is a local stand-in of the webhook endpoints, it records the payload posted to every path.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads map[string][]byte
+}
+
+func newWebhookServer(t *testing.T) *webhookServer {
+	s := &webhookServer{payloads: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.payloads[r.URL.Path] = body
+		s.mu.Unlock()
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/failing/") {
+			http.Error(w, "no_service", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// payload decodes the payload posted to path into v, it reports whether one was posted.
+func (s *webhookServer) payload(t *testing.T, path string, v interface{}) bool {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, ok := s.payloads[path]
+	if ok {
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("invalid payload %s: %v", body, err)
+		}
+	}
+	return ok
+}
+
+func testReport(changed, failed bool) *report.Report {
+	r := &report.Report{
+		Command:  "sync",
+		Duration: 90*time.Second + 400*time.Millisecond,
+		Records: []report.Record{
+			{Action: "skipped-up-to-date", Destination: "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/busybox", Source: "busybox", Tag: "1.36"},
+		},
+		Totals: report.Totals{Processed: 1, Succeeded: 1, Total: 1},
+	}
+	if changed {
+		r.Records = append(r.Records, report.Record{Action: "copied", Changed: true, Destination: "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine", Source: "alpine", Tag: "3.17"})
+		r.Totals.Processed, r.Totals.Succeeded, r.Totals.Total = r.Totals.Processed+1, r.Totals.Succeeded+1, r.Totals.Total+1
+	}
+	if failed {
+		r.Records = append(r.Records, report.Record{Action: "failed", Destination: "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/nginx", Error: "denied", Source: "nginx", Tag: "1.23"})
+		r.Totals.Failed, r.Totals.Processed, r.Totals.Total = r.Totals.Failed+1, r.Totals.Processed+1, r.Totals.Total+1
+	}
+	return r
+}
+
+func TestParseWebhook(t *testing.T) {
+	for _, c := range []struct {
+		spec, format, url string
+		invalid           bool
+	}{
+		{"https://example.com/hooks/mirror", FormatJSON, "https://example.com/hooks/mirror", false},
+		{"json=http://alerts.internal:8080/mirror", FormatJSON, "http://alerts.internal:8080/mirror", false},
+		{"slack=https://hooks.slack.com/services/T0000/B0000/XXXXXXXX", FormatSlack, "https://hooks.slack.com/services/T0000/B0000/XXXXXXXX", false},
+		{"teams=https://example.webhook.office.com/webhookb2/abc", FormatTeams, "https://example.webhook.office.com/webhookb2/abc", false},
+		{"https://example.com/hook?token=abcdef&channel=mirror", FormatJSON, "https://example.com/hook?token=abcdef&channel=mirror", false},
+		{"discord=https://discord.com/api/webhooks/1/abc", "", "", true},
+		{"slack=ftp://hooks.slack.com/services/T0000/B0000/XXXXXXXX", "", "", true},
+		{"hooks.slack.com/services/T0000/B0000/XXXXXXXX", "", "", true},
+	} {
+		webhook, err := ParseWebhook(c.spec)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("ParseWebhook(%q) accepted an invalid webhook", c.spec)
+			} else if strings.Contains(err.Error(), "XXXXXXXX") {
+				t.Errorf("ParseWebhook(%q) error leaks the url: %v", c.spec, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWebhook(%q) returned %v", c.spec, err)
+			continue
+		}
+		if webhook.Format != c.format || webhook.URL != c.url {
+			t.Errorf("ParseWebhook(%q) = %+v, want %s %s", c.spec, webhook, c.format, c.url)
+		}
+		if got := redact.String("posting to " + webhook.URL); got != "posting to "+redact.Mask {
+			t.Errorf("the url of %q is not redacted: %s", c.spec, got)
+		}
+	}
+}
+
+func TestNewRejectsInvalidOptions(t *testing.T) {
+	if _, err := New(nil, []string{OnFailure, "sometimes"}, ""); err == nil {
+		t.Error("New accepted an unknown filter")
+	}
+	if _, err := New(nil, nil, "{{ .Command "); err == nil {
+		t.Error("New accepted an invalid template")
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	for _, c := range []struct {
+		on                       []string
+		unchanged, changed       bool
+		failed, changedAndFailed bool
+	}{
+		{nil, true, true, true, true},
+		{[]string{OnAlways}, true, true, true, true},
+		{[]string{OnChange}, false, true, false, true},
+		{[]string{OnFailure}, false, false, true, true},
+		{[]string{OnChange, OnFailure}, false, true, true, true},
+	} {
+		n, err := New(nil, c.on, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []struct {
+			name   string
+			report *report.Report
+			want   bool
+		}{
+			{"unchanged", testReport(false, false), c.unchanged},
+			{"changed", testReport(true, false), c.changed},
+			{"failed", testReport(false, true), c.failed},
+			{"changed and failed", testReport(true, true), c.changedAndFailed},
+		} {
+			if got := n.ShouldNotify(r.report); got != r.want {
+				t.Errorf("on %v, a %s run notifies = %t, want %t", c.on, r.name, got, r.want)
+			}
+		}
+	}
+}
+
+func TestNotifyPayloads(t *testing.T) {
+	server := newWebhookServer(t)
+	n, err := New([]Webhook{
+		{Format: FormatJSON, URL: server.URL + "/json"},
+		{Format: FormatSlack, URL: server.URL + "/slack"},
+		{Format: FormatTeams, URL: server.URL + "/teams"},
+	}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testReport(true, true)); err != nil {
+		t.Fatal(err)
+	}
+	const message = "ecr-mirror-sync sync: 2 copied, 1 failed of 3 images in 1m30s"
+
+	var generic struct {
+		Changes  []report.Record `json:"changes"`
+		Command  string          `json:"command"`
+		Failures []report.Record `json:"failures"`
+		Message  string          `json:"message"`
+		Totals   report.Totals   `json:"totals"`
+	}
+	if !server.payload(t, "/json", &generic) {
+		t.Fatal("nothing was posted to the json webhook")
+	}
+	if generic.Command != "sync" || generic.Message != message || generic.Totals.Failed != 1 || generic.Totals.Total != 3 {
+		t.Errorf("json payload = %+v", generic)
+	}
+	if len(generic.Changes) != 1 || generic.Changes[0].Source != "alpine" || len(generic.Failures) != 1 || generic.Failures[0].Error != "denied" {
+		t.Errorf("json payload changes %+v and failures %+v, want alpine and nginx", generic.Changes, generic.Failures)
+	}
+
+	var slack slackMessage
+	if !server.payload(t, "/slack", &slack) {
+		t.Fatal("nothing was posted to the slack webhook")
+	}
+	if slack.Text != message || len(slack.Attachments) != 2 {
+		t.Fatalf("slack payload = %+v", slack)
+	}
+	failed, updated := slack.Attachments[0], slack.Attachments[1]
+	if failed.Color != "danger" || failed.Title != "Failed mirrors" || failed.Text != "- nginx:1.23 -> 123456789012.dkr.ecr.us-east-1.amazonaws.com/external/nginx (failed): denied\n" {
+		t.Errorf("slack failures = %+v", failed)
+	}
+	if updated.Color != "good" || updated.Title != "Updated mirrors" || !strings.Contains(updated.Text, "alpine:3.17") {
+		t.Errorf("slack changes = %+v", updated)
+	}
+
+	var teams teamsMessageCard
+	if !server.payload(t, "/teams", &teams) {
+		t.Fatal("nothing was posted to the teams webhook")
+	}
+	if teams.Type != "MessageCard" || teams.Context != "https://schema.org/extensions" || teams.Summary != message || teams.Text != message || teams.ThemeColor != "D00000" {
+		t.Errorf("teams payload = %+v", teams)
+	}
+	if len(teams.Sections) != 2 || teams.Sections[0].ActivityTitle != "Failed mirrors" || teams.Sections[1].ActivityTitle != "Updated mirrors" {
+		t.Errorf("teams sections = %+v", teams.Sections)
+	}
+}
+
+func TestNotifyWithoutFailuresOrChanges(t *testing.T) {
+	server := newWebhookServer(t)
+	n, err := New([]Webhook{{Format: FormatSlack, URL: server.URL + "/slack"}, {Format: FormatTeams, URL: server.URL + "/teams"}}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testReport(false, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	var slack slackMessage
+	if server.payload(t, "/slack", &slack); len(slack.Attachments) != 0 {
+		t.Errorf("slack attachments = %+v, want none", slack.Attachments)
+	}
+	var teams teamsMessageCard
+	if server.payload(t, "/teams", &teams); teams.ThemeColor != "2EB886" || len(teams.Sections) != 0 {
+		t.Errorf("teams payload = %+v, want a green card without sections", teams)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	server := newWebhookServer(t)
+	n, err := New([]Webhook{{Format: FormatJSON, URL: server.URL + "/json"}}, nil, `{{ .Command }} took {{ round .Duration }}{{ range .Records }}{{ if .Error }}, {{ .Source }} failed{{ end }}{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testReport(false, true)); err != nil {
+		t.Fatal(err)
+	}
+
+	var generic jsonPayload
+	server.payload(t, "/json", &generic)
+	if want := "sync took 1m30s, nginx failed"; generic.Message != want {
+		t.Errorf("message = %q, want %q", generic.Message, want)
+	}
+
+	n, err = New([]Webhook{{Format: FormatJSON, URL: server.URL + "/json"}}, nil, `{{ .Missing }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testReport(false, false)); err == nil {
+		t.Error("Notify succeeded although the template failed")
+	}
+}
+
+func TestNotifyFilters(t *testing.T) {
+	for _, c := range []struct {
+		on     string
+		report *report.Report
+		posted bool
+	}{
+		{OnFailure, testReport(true, false), false},
+		{OnFailure, testReport(false, true), true},
+		{OnChange, testReport(false, true), false},
+		{OnChange, testReport(true, false), true},
+	} {
+		server := newWebhookServer(t)
+		n, err := New([]Webhook{{Format: FormatJSON, URL: server.URL + "/json"}}, []string{c.on}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Notify(context.Background(), c.report); err != nil {
+			t.Fatal(err)
+		}
+		var generic jsonPayload
+		if posted := server.payload(t, "/json", &generic); posted != c.posted {
+			t.Errorf("on %s with %d changes and %d failures posted = %t, want %t", c.on, len(changes(c.report)), len(failures(c.report)), posted, c.posted)
+		}
+	}
+}
+
+func TestNotifyErrorsDoNotLeakTheURL(t *testing.T) {
+	server := newWebhookServer(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	n, err := New([]Webhook{
+		{Format: FormatSlack, URL: closed.URL + "/services/T0000/B0000/XXXXXXXX"},
+		{Format: FormatTeams, URL: server.URL + "/failing/YYYYYYYY"},
+		{Format: FormatJSON, URL: server.URL + "/json"},
+	}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = n.Notify(context.Background(), testReport(true, false))
+	if err == nil {
+		t.Fatal("Notify succeeded although two webhooks failed")
+	}
+	for _, leaked := range []string{"XXXXXXXX", "YYYYYYYY", "/services/"} {
+		if strings.Contains(err.Error(), leaked) {
+			t.Errorf("the error leaks the webhook url: %v", err)
+		}
+	}
+	for _, want := range []string{"slack webhook: Post request failed", "teams webhook: unexpected status 500"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("the error %q does not contain %q", err, want)
+		}
+	}
+
+	var generic jsonPayload
+	if !server.payload(t, "/json", &generic) {
+		t.Error("the json webhook was not posted to after the others failed")
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	if err := n.Notify(context.Background(), testReport(true, true)); err != nil {
+		t.Errorf("Notify of a nil Notifier returned %v", err)
+	}
+}
@@ -1,9 +1,15 @@
 package options
 
 import (
+	"ecr-mirror-sync/pkg/events"
+	"ecr-mirror-sync/pkg/retry"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/containers/common/pkg/retry"
+	"github.com/docker/go-units"
 	"github.com/spf13/pflag"
 )
 
@@ -46,13 +52,15 @@ func GlobalFlags() (pflag.FlagSet, *GlobalOptions) {
 	return fs, &opts
 }
 
-func RetryFlags() (pflag.FlagSet, *retry.RetryOptions) {
-	opts := retry.RetryOptions{}
+func RetryFlags() (pflag.FlagSet, *retry.Options) {
+	opts := retry.Options{}
 	fs := pflag.FlagSet{}
-	fs.IntVar(&opts.MaxRetry, "retry-times", 0, "the number of times to possibly retry")
+	fs.Var((*retryTimes)(&opts), "retry-times", "the number of times to possibly retry, AWS API calls are retried 3 times when unset")
+	fs.DurationVar(&opts.Delay, "retry-delay", time.Second, "delay before the first retry, doubled with jitter for every further one")
+	fs.DurationVar(&opts.MaxDelay, "retry-max-delay", 30*time.Second, "upper bound of the delay between retries")
 	return fs, &opts
 }
-func MirrorFlags(global *GlobalOptions, srcOpts *ImageOptions, destOpts *ImageDestOptions, retryOpts *retry.RetryOptions) (pflag.FlagSet, *MirrorOptions) {
+func MirrorFlags(global *GlobalOptions, srcOpts *ImageOptions, destOpts *ImageDestOptions, retryOpts *retry.Options) (pflag.FlagSet, *MirrorOptions) {
 	flags := MirrorOptions{
 		Global:           global,
 		RetryOpts:        retryOpts,
@@ -66,15 +74,123 @@ func MirrorFlags(global *GlobalOptions, srcOpts *ImageOptions, destOpts *ImageDe
 	fs.BoolVar(&flags.Debug, "debug", false, "enable debug output")
 	fs.BoolVar(&flags.DryRun, "dry-run", false, "Run without actually copying data")
 	fs.BoolVar(&flags.RenderTable, "render-table", false, "Render tables")
+	fs.StringVar(&flags.Endpoints.ECR, "ecr-endpoint", "", "custom endpoint `URL` for the ECR API")
+	fs.StringVar(&flags.Endpoints.STS, "sts-endpoint", "", "custom endpoint `URL` for STS")
+	fs.StringVar(&flags.Endpoints.Tagging, "tagging-endpoint", "", "custom endpoint `URL` for the Resource Groups Tagging API")
+	fs.StringVar(&flags.RegistryHost, "registry-host", "", "ecr registry `HOST` to push to, default is derived from the repository account, region and partition")
 	fs.StringVar(&flags.MirrorRepoPrefix, "prefix", "", "prefix for external images in ecr")
 	fs.StringVar(&flags.Region, "region", "us-east-1", "ecr region for to interactive with")
 	fs.StringVar(&flags.UpstreamImageKey, "image-key", "upstream-image", "aws resource tag for upstream image")
 	fs.StringVar(&flags.UpstreamTagsKey, "tag-key", "upstream-tags", "aws resource tag for upstream tags")
-	fs.StringVar(&flags.WorkerPoolSize, "batch", "", "batch size for syncing images, default is all")
+	fs.IntVar(&flags.WorkerPoolSize, "batch", 0, "batch size for syncing images, default is all")
+	fs.StringToIntVar(&flags.RegistryWorkers, "registry-concurrency", nil, "cap the images synced at once per upstream registry with `HOST=N` pairs, e.g. docker.io=2,ghcr.io=8, within --batch")
+	fs.Var((*byteRate)(&flags.BandwidthLimit), "bandwidth-limit", "limit the blobs copied by all images together to `RATE` bytes per second, e.g. 50MB, default is unlimited")
+	flags.FailOn = FailOnAny
+	fs.Var((*failurePolicy)(&flags.FailOn), "fail-on", "exit non-zero when mirrors fail: any (more than --max-failures), all or none")
+	fs.IntVar(&flags.MaxFailures, "max-failures", 0, "number of failed mirrors tolerated before exiting non-zero with --fail-on=any")
+	fs.DurationVar(&flags.CopyTimeout, "copy-timeout", 20*time.Minute, "fail copying a single image after `DURATION`, 0 never times out")
+	fs.DurationVar(&flags.ManifestTimeout, "manifest-timeout", time.Minute, "fail looking up the digests of a single image after `DURATION`, 0 never times out")
+	fs.DurationVar(&flags.TotalTimeout, "total-timeout", 0, "stop starting images after `DURATION`, like on SIGTERM, default never times out")
+	fs.BoolVar(&flags.DockerHubLimit, "dockerhub-ratelimit", true, "read the docker hub pull rate limit before and while syncing, prioritize changed images and defer the rest once it is spent")
+	fs.IntVar(&flags.DockerHubReserve, "dockerhub-reserve", 0, "docker hub pulls to leave for other clients sharing the rate limit")
+	fs.DurationVar(&flags.ShutdownGrace, "shutdown-grace-period", 25*time.Second, "on SIGINT or SIGTERM, let in-flight copies finish for up to `DURATION` before canceling them")
 
 	return fs, &flags
 }
 
+func LogFlags() (pflag.FlagSet, *LogOptions) {
+	opts := LogOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Format, "log-format", LogFormatText, "log message format: text or json")
+	fs.StringVar(&opts.Level, "log-level", "info", "minimum log `LEVEL`: trace, debug, info, warn or error")
+	return fs, &opts
+}
+
+func ServeFlags() (pflag.FlagSet, *ServeOptions) {
+	opts := ServeOptions{}
+	fs := pflag.FlagSet{}
+	fs.BoolVar(&opts.AllowUnauthenticated, "api-allow-unauthenticated", false, "serve the api without an --api-token-file, any client reaching it can copy images into ecr")
+	fs.StringVar(&opts.APITokenFile, "api-token-file", "", "require one of the bearer tokens in `PATH`, one per line, for api requests")
+	fs.DurationVar(&opts.Interval, "interval", 0, "sync every `DURATION` after the previous sync finished, e.g. 6h")
+	fs.DurationVar(&opts.Jitter, "jitter", 0, "delay every sync by a random duration of up to `DURATION`")
+	fs.StringVar(&opts.Listen, "listen", "", "serve the http api on `ADDRESS`, e.g. :8080")
+	fs.BoolVar(&opts.RunOnStart, "run-on-start", true, "sync once at startup before following the interval or schedule")
+	fs.StringVar(&opts.Schedule, "schedule", "", "sync on a cron `SPEC`, e.g. \"0 */6 * * *\"")
+	fs.StringVar(&opts.WebhookSecretFile, "webhook-secret-file", "", "receive registry push webhooks validated by the secret in `PATH`")
+	return fs, &opts
+}
+
+func MetricsFlags() (pflag.FlagSet, *MetricsOptions) {
+	opts := MetricsOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.PushgatewayURL, "metrics-pushgateway", "", "push sync metrics to the Pushgateway at `URL`")
+	fs.StringVar(&opts.Job, "metrics-job", "ecr-mirror-sync", "job name used when pushing metrics")
+	fs.StringVar(&opts.Textfile, "metrics-textfile", "", "write sync metrics to `PATH` for the node-exporter textfile collector")
+	return fs, &opts
+}
+
+func TracingFlags() (pflag.FlagSet, *TracingOptions) {
+	opts := TracingOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Endpoint, "otlp-endpoint", "", "export traces to the OTLP/HTTP collector at `HOST:PORT`")
+	fs.BoolVar(&opts.Insecure, "otlp-insecure", false, "export traces over plain HTTP")
+	fs.Float64Var(&opts.SampleRatio, "trace-sample-ratio", 1, "fraction of runs to trace")
+	return fs, &opts
+}
+
+func EventsFlags() (pflag.FlagSet, *EventsOptions) {
+	opts := EventsOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.TopicARN, "events-sns-topic", "", "publish an event per image to the SNS topic `ARN`")
+	fs.StringVar(&opts.EventBus, "events-bus", "", "publish an event per image to the EventBridge bus `NAME`")
+	fs.StringVar(&opts.Source, "events-source", "ecr-mirror-sync", "source of the published EventBridge events")
+	fs.Var((*eventTypes)(&opts.Types), "events-types", "only publish these event types: copied, mutated, skipped, deferred, dry-run or failed, default is all")
+	return fs, &opts
+}
+
+func NotifyFlags() (pflag.FlagSet, *NotifyOptions) {
+	opts := NotifyOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringArrayVar(&opts.Webhooks, "notify-webhook", nil, "post a run summary to `[FORMAT=]URL`, FORMAT is json (default), slack or teams, may be repeated")
+	fs.StringSliceVar(&opts.On, "notify-on", []string{"always"}, "only notify on: always, change or failure")
+	fs.StringVar(&opts.Template, "notify-template", "", "go template for the notification message, rendered with the run report")
+	return fs, &opts
+}
+
+func ReportFlags() (pflag.FlagSet, *ReportOptions) {
+	opts := ReportOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Format, "report-format", "", "write a report of the run as json, junit or markdown")
+	fs.StringVar(&opts.File, "report-file", "-", "`PATH` to write the report to, - for stdout")
+	fs.BoolVar(&opts.ChangedOnly, "report-changed-only", false, "only report images whose upstream digest changed since the previous run, requires --state")
+	return fs, &opts
+}
+
+func LockFlags() (pflag.FlagSet, *LockOptions) {
+	opts := LockOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Location, "lock", "", "skip the sync while another holds the lease at dynamodb://TABLE[/NAME] or s3://BUCKET/KEY")
+	fs.DurationVar(&opts.TTL, "lock-ttl", 5*time.Minute, "lease `DURATION` of --lock, renewed every third of it")
+	return fs, &opts
+}
+
+func ResumeFlags() (pflag.FlagSet, *ResumeOptions) {
+	opts := ResumeOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Journal, "journal", "", "record the progress of the sync in a journal file `PATH`")
+	fs.BoolVar(&opts.Resume, "resume", false, "skip the entries completed by the interrupted sync recorded in --journal")
+	fs.StringVar(&opts.RetryFailed, "retry-failed", "", "only sync the entries which failed in an earlier json `REPORT`")
+	return fs, &opts
+}
+
+func StateFlags() (pflag.FlagSet, *StateOptions) {
+	opts := StateOptions{}
+	fs := pflag.FlagSet{}
+	fs.StringVar(&opts.Location, "state", "", "remember digests across runs in a file `PATH`, s3://BUCKET/KEY or dynamodb://TABLE")
+	fs.DurationVar(&opts.TTL, "state-ttl", 0, "skip images verified by a previous run within `DURATION`, default never skips")
+	return fs, &opts
+}
+
 // ImageDestFlags prepares a collection of CLI flags writing into ImageDestOptions, and the managed ImageDestOptions structure.
 func ImageDestFlags(global *GlobalOptions, flagPrefix, credsOptionAlias string) (pflag.FlagSet, *ImageDestOptions) {
 	_, genericOptions := ImageFlags(global, flagPrefix, credsOptionAlias)
@@ -83,3 +199,98 @@ func ImageDestFlags(global *GlobalOptions, flagPrefix, credsOptionAlias string)
 	fs.BoolVar(&opts.precomputeDigests, flagPrefix+"precompute-digests", true, "Precompute digests to prevent uploading layers already on the registry using the 'docker' transport.")
 	return fs, &opts
 }
+
+// byteRate is a flag value of a byte rate in human readable form, e.g. 50MB or 1.5GB.
+type byteRate int64
+
+func (r *byteRate) Set(value string) error {
+	size, err := units.FromHumanSize(value)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("negative rate %q", value)
+	}
+	*r = byteRate(size)
+	return nil
+}
+
+func (r *byteRate) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return units.HumanSize(float64(*r))
+}
+
+func (r *byteRate) Type() string {
+	return "RATE"
+}
+
+// failurePolicy is a flag value of a --fail-on policy, unknown policies are rejected while parsing the flags.
+type failurePolicy string
+
+func (f *failurePolicy) Set(value string) error {
+	switch value {
+	case FailOnAll, FailOnAny, FailOnNone:
+		*f = failurePolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unknown failure policy %q, expected %s, %s or %s", value, FailOnAny, FailOnAll, FailOnNone)
+}
+
+func (f *failurePolicy) String() string {
+	return string(*f)
+}
+
+func (f *failurePolicy) Type() string {
+	return "string"
+}
+
+// retryTimes is the flag value of --retry-times, it records that the number of retries was configured.
+type retryTimes retry.Options
+
+func (r *retryTimes) Set(value string) error {
+	times, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if times < 0 {
+		return fmt.Errorf("negative number of retries %q", value)
+	}
+	r.MaxRetry, r.MaxRetrySet = times, true
+	return nil
+}
+
+func (r *retryTimes) String() string {
+	return strconv.Itoa(r.MaxRetry)
+}
+
+func (r *retryTimes) Type() string {
+	return "int"
+}
+
+// eventTypes is the flag value of --events-types, a comma separated list which may be repeated. Unknown event
+// types are rejected while parsing the flags.
+type eventTypes []string
+
+func (e *eventTypes) Set(value string) error {
+	for _, eventType := range strings.Split(value, ",") {
+		eventType = strings.TrimSpace(eventType)
+		switch eventType {
+		case events.TypeCopied, events.TypeDeferred, events.TypeDryRun, events.TypeFailed, events.TypeMutated, events.TypeSkipped:
+			*e = append(*e, eventType)
+		default:
+			return fmt.Errorf("unknown event type %q, expected %s, %s, %s, %s, %s or %s", eventType,
+				events.TypeCopied, events.TypeMutated, events.TypeSkipped, events.TypeDeferred, events.TypeDryRun, events.TypeFailed)
+		}
+	}
+	return nil
+}
+
+func (e *eventTypes) String() string {
+	return strings.Join(*e, ",")
+}
+
+func (e *eventTypes) Type() string {
+	return "strings"
+}
@@ -2,10 +2,11 @@ package options
 
 import (
 	"context"
+	"ecr-mirror-sync/pkg/retry"
 	"errors"
+	"os"
 	"time"
 
-	"github.com/containers/common/pkg/retry"
 	"github.com/containers/image/v5/signature"
 	"github.com/containers/image/v5/types"
 )
@@ -16,27 +17,164 @@ const (
 	RemoteTransport  = "docker"
 )
 
+// Failure policies accepted by --fail-on.
+const (
+	FailOnAll  = "all"  // fail only when every mirror failed
+	FailOnAny  = "any"  // fail when the failed mirrors exceed --max-failures
+	FailOnNone = "none" // never fail because of mirror failures
+)
+
 // errorShouldDisplayUsage is a subtype of error used by command handlers to indicate that cli.ShowSubcommandHelp should be called.
 type ErrorShouldDisplayUsage struct {
 	error
 }
 
+// ConfigError is a subtype of error used to tell invalid configuration, flags or credentials apart from mirror failures.
+type ConfigError struct {
+	error
+}
+
+// NewConfigError wraps err in a ConfigError.
+func NewConfigError(err error) error {
+	return ConfigError{err}
+}
+
+func (e ConfigError) Unwrap() error {
+	return e.error
+}
+
 type MirrorOptions struct {
-	AdditionalTags   []string // For docker-archive: destinations, in addition to the name:tag specified as destination, also add these
-	Debug            bool     // Enable debug output
+	AdditionalTags   []string      // For docker-archive: destinations, in addition to the name:tag specified as destination, also add these
+	BandwidthLimit   int64         // Blob bytes copied per second by all workers together, 0 is unlimited
+	CopyTimeout      time.Duration // Timeout of copying a single image, 0 never times out
+	Debug            bool          // Enable debug output
 	DestImage        *ImageDestOptions
+	DockerHubLimit   bool // Budget the Docker Hub pull rate limit and defer images once it is spent
+	DockerHubReserve int  // Pulls of the Docker Hub rate limit left for other clients
 	DryRun           bool // Dry run does not copy
+	Endpoints        AWSEndpoints
+	FailOn           string // Failure policy, one of FailOnAny, FailOnAll or FailOnNone
 	Global           *GlobalOptions
+	ManifestTimeout  time.Duration // Timeout of looking up the ECR and upstream digests of a single image, 0 never times out
+	MaxFailures      int           // Number of failed mirrors tolerated by FailOnAny
 	MirrorRepoPrefix string
-	Quiet            bool   // Suppress output information when copying images
-	Region           string // aws region use for ecr repos
-	RemoveSignatures bool   // Do not copy signatures from the source image
-	RenderTable      bool   //
-	RetryOpts        *retry.RetryOptions
+	Output           *os.File       // Copy progress and rendered tables, nil writes them to stdout
+	Quiet            bool           // Suppress output information when copying images
+	Region           string         // aws region use for ecr repos
+	RegistryHost     string         // Overrides the ECR registry hostname derived from the repository ARN
+	RegistryWorkers  map[string]int // Number of images copied at once per upstream registry host, within WorkerPoolSize
+	RemoveSignatures bool           // Do not copy signatures from the source image
+	RenderTable      bool           //
+	RetryOpts        *retry.Options
+	ShutdownGrace    time.Duration // In-flight copies may finish within ShutdownGrace after a shutdown was requested
 	SrcImage         *ImageOptions
+	TotalTimeout     time.Duration // Timeout of a whole run, 0 never times out
 	UpstreamImageKey string
 	UpstreamTagsKey  string
-	WorkerPoolSize   string
+	WorkerPoolSize   int // Number of images synced concurrently, 0 syncs all at once
+}
+
+// AWSEndpoints holds optional endpoint overrides for the AWS services used by the tool.
+// An empty value falls back to the default endpoint for the configured region.
+type AWSEndpoints struct {
+	ECR     string // ECR API endpoint
+	STS     string // STS endpoint, used when exchanging web identity tokens (IRSA)
+	Tagging string // Resource Groups Tagging API endpoint
+}
+
+// MetricsOptions configures where the Prometheus metrics of a sync run are exported to.
+type MetricsOptions struct {
+	Job            string // Pushgateway job name
+	PushgatewayURL string // Pushgateway compatible endpoint, empty disables pushing
+	Textfile       string // node-exporter textfile collector file, empty disables writing
+}
+
+// Enabled reports whether any metrics exporter is configured.
+func (opts *MetricsOptions) Enabled() bool {
+	return opts.PushgatewayURL != "" || opts.Textfile != ""
+}
+
+// TracingOptions configures the OpenTelemetry trace exporter.
+type TracingOptions struct {
+	Endpoint    string  // OTLP/HTTP collector host:port, empty disables tracing
+	Insecure    bool    // Export over plain HTTP
+	SampleRatio float64 // Fraction of runs to trace
+}
+
+// EventsOptions configures where mirror events are published to.
+type EventsOptions struct {
+	EventBus string   // EventBridge bus name or ARN, empty disables EventBridge
+	Source   string   // EventBridge event source
+	TopicARN string   // SNS topic ARN, empty disables SNS
+	Types    []string // Only publish events of these types, all when empty
+}
+
+// NotifyOptions configures the webhooks notified after a sync or copy run.
+type NotifyOptions struct {
+	On       []string // Only notify on these conditions: always, change or failure
+	Template string   // text/template for the summary message
+	Webhooks []string // [FORMAT=]URL of each webhook
+}
+
+// Log output formats accepted by --log-format.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// LogOptions configures the format and verbosity of log messages.
+type LogOptions struct {
+	Format string // One of LogFormatText or LogFormatJSON
+	Level  string // Minimum logrus level, e.g. info or debug
+}
+
+// ServeOptions configures the schedule of the serve command.
+type ServeOptions struct {
+	APITokenFile string        // File holding the bearer tokens accepted by the API, one per line
+	Interval     time.Duration // Time between the end of a sync and the start of the next one
+	Jitter       time.Duration // Random delay of up to Jitter added to every start
+	Listen       string        // Address the API listens on, empty disables the API
+	RunOnStart   bool          // Sync once at startup before following the schedule
+	Schedule     string        // Standard five field cron spec, mutually exclusive with Interval
+
+	AllowUnauthenticated bool   // Serve the API without an APITokenFile
+	WebhookSecretFile    string // File holding the secret validating registry webhooks, empty disables them
+}
+
+// Scheduled reports whether syncs run on an interval or cron schedule.
+func (opts *ServeOptions) Scheduled() bool {
+	return opts.Interval != 0 || opts.Schedule != ""
+}
+
+// ReportOptions configures the machine readable report written after a run.
+type ReportOptions struct {
+	ChangedOnly bool   // Only report the mirrors whose upstream digest changed since the previous run
+	File        string // Path of the report, "-" for stdout
+	Format      string // One of json, junit or markdown, empty disables the report
+}
+
+// ToStdout reports whether the report is written to stdout.
+func (opts *ReportOptions) ToStdout() bool {
+	return opts.Format != "" && (opts.File == "" || opts.File == "-")
+}
+
+// LockOptions configures the lease which keeps syncs of the same account from overlapping.
+type LockOptions struct {
+	Location string        // dynamodb://TABLE[/NAME] or s3://BUCKET/KEY, empty disables the lock
+	TTL      time.Duration // Lease duration, renewed every third of it
+}
+
+// ResumeOptions configures which entries of a sync are handled again after an interrupted or failed run.
+type ResumeOptions struct {
+	Journal     string // Local file recording the progress of the run, empty disables the journal
+	Resume      bool   // Skip the entries completed by the interrupted run recorded in Journal
+	RetryFailed string // Json report of an earlier run, only its failed entries are synced
+}
+
+// StateOptions configures where the digests seen by a run are remembered for the next one.
+type StateOptions struct {
+	Location string        // File path, s3://BUCKET/KEY or dynamodb://TABLE, empty disables the state
+	TTL      time.Duration // Skip mirrors verified within TTL
 }
 
 type ManifestOptions struct {
@@ -44,7 +182,7 @@ type ManifestOptions struct {
 	Global        *GlobalOptions
 	Image         ImageOptions
 	Raw           bool // Output the raw manifest instead of parsing information about the image
-	RetryOpts     *retry.RetryOptions
+	RetryOpts     *retry.Options
 }
 
 type DockerImageOptions struct {
@@ -105,15 +243,13 @@ func (opts *GlobalOptions) newSystemContext() *types.SystemContext {
 	return ctx
 }
 
-// TimeoutContext returns a context.Context and a cancellation callback based on opts.
+// TimeoutContext returns a context.Context derived from parent and a cancellation callback based on opts.
 // The caller should usually "defer cancel()" immediately after calling this.
-func (opts *GlobalOptions) TimeoutContext() (context.Context, context.CancelFunc) {
-	ctx := context.Background()
-	var cancel context.CancelFunc = func() {}
+func (opts *GlobalOptions) TimeoutContext(parent context.Context) (context.Context, context.CancelFunc) {
 	if opts.CommandTimeout > 0 {
-		ctx, cancel = context.WithTimeout(ctx, opts.CommandTimeout)
+		return context.WithTimeout(parent, opts.CommandTimeout)
 	}
-	return ctx, cancel
+	return context.WithCancel(parent)
 }
 
 // NewSystemContext returns a *types.SystemContext corresponding to opts.
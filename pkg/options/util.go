@@ -2,15 +2,18 @@ package options
 
 import (
 	"context"
+	"ecr-mirror-sync/pkg/retry"
 	"errors"
-	"log"
+	"fmt"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
 )
@@ -20,6 +23,10 @@ var (
 	UpstreamTags     = aws.String("upstream-tags")
 	ECRypeFilter     = []*string{aws.String("ecr:repository")}
 	DefaultECRRegion = aws.String("us-east-1")
+
+	// Optional repository tags overriding --copy-timeout and --manifest-timeout for a single repository.
+	UpstreamCopyTimeout     = aws.String("upstream-copy-timeout")
+	UpstreamManifestTimeout = aws.String("upstream-manifest-timeout")
 )
 
 func GetDockerAuth(creds string) (*types.DockerAuthConfig, error) {
@@ -61,8 +68,51 @@ func ParseImageSource(ctx context.Context, opts *ImageOptions, name string) (typ
 	return ref.NewImageSource(ctx, sys)
 }
 
-func GetDefaultAwsClient(region *string) *session.Session {
-	return session.Must(session.NewSessionWithOptions(session.Options{Config: aws.Config{Region: region}, SharedConfigState: session.SharedConfigEnable}))
+// GetDefaultAwsClient returns a session for region, retrying throttled and failed calls as configured by retryOpts.
+func GetDefaultAwsClient(region *string, overrides *AWSEndpoints, retryOpts *retry.Options) *session.Session {
+	config := aws.Config{Region: region}
+	if retryOpts != nil {
+		request.WithRetryer(&config, retryOpts.AWSRetryer())
+	}
+	if overrides != nil {
+		config.EndpointResolver = overrides.resolver()
+	}
+	sess := session.Must(session.NewSessionWithOptions(session.Options{Config: config, SharedConfigState: session.SharedConfigEnable}))
+	if retryOpts != nil {
+		sess.Handlers.UnmarshalError.PushBackNamed(retry.AWSRetryAfter)
+	}
+	return sess
+}
+
+// resolver returns an endpoints.Resolver which serves the configured overrides and
+// defers to the SDK's default resolver for everything else.
+func (e *AWSEndpoints) resolver() endpoints.Resolver {
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		var url string
+
+		switch service {
+		case ecr.EndpointsID:
+			url = e.ECR
+		case sts.EndpointsID:
+			url = e.STS
+		case resourcegroupstaggingapi.EndpointsID:
+			url = e.Tagging
+		}
+		if url != "" {
+			return endpoints.ResolvedEndpoint{URL: url, SigningRegion: region}, nil
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
+
+// ECRRegistryHost returns the registry hostname for an account's private ECR registry in region.
+// The DNS suffix follows the region's partition, e.g. amazonaws.com.cn for the China regions.
+func ECRRegistryHost(accountID, region string) string {
+	dnsSuffix := "amazonaws.com"
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		dnsSuffix = partition.DNSSuffix()
+	}
+	return fmt.Sprintf("%s.dkr.ecr.%s.%s", accountID, region, dnsSuffix)
 }
 
 func ECRRepofilters() *resourcegroupstaggingapi.GetResourcesInput {
@@ -81,32 +131,17 @@ func ECRRepofilters() *resourcegroupstaggingapi.GetResourcesInput {
 	}
 }
 
-func GetECRAuthToken(region *string) (*string, error) {
-	svc := ecr.New(GetDefaultAwsClient(region))
+func GetECRAuthToken(sess *session.Session) (*ecr.AuthorizationData, error) {
+	svc := ecr.New(sess)
 	input := &ecr.GetAuthorizationTokenInput{}
 	ecrToken, err := svc.GetAuthorizationToken(input)
 
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case ecr.ErrCodeServerException:
-				log.Println(ecr.ErrCodeServerException, aerr.Error())
-				return nil, err
-			case ecr.ErrCodeInvalidParameterException:
-				log.Println(ecr.ErrCodeInvalidParameterException, aerr.Error())
-				return nil, err
-			default:
-				log.Println(aerr.Error())
-				return nil, err
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Println(err.Error())
-
-			return nil, err
-		}
+		return nil, err
+	}
+	if len(ecrToken.AuthorizationData) == 0 {
+		return nil, errors.New("no authorization data returned")
 	}
 
-	return ecrToken.AuthorizationData[0].AuthorizationToken, nil
+	return ecrToken.AuthorizationData[0], nil
 }
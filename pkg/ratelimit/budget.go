@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// recheckAfter bounds how many pulls are counted locally before the limit is read again,
+// so pulls by other clients sharing the limit are noticed between copies.
+const recheckAfter = 20
+
+// recheckInterval is how long an exhausted budget waits before reading the limit again.
+const recheckInterval = 30 * time.Second
+
+// Budget hands out the pulls left in a rate limit, keeping Reserve pulls for other clients.
+// It is safe for concurrent use, a nil Budget never runs out.
+type Budget struct {
+	checker   *Checker
+	checkedAt time.Time
+	checking  bool // A Take reads the limit again, the others keep counting meanwhile
+	mu        sync.Mutex
+	remaining int // pulls left when the limit was last read, minus those taken since
+	reserve   int
+	taken     int // pulls taken since the limit was last read
+	unlimited bool
+}
+
+// NewBudget reads the current limit with checker and returns the budget of the pulls left above reserve.
+func NewBudget(ctx context.Context, checker *Checker, reserve int) (*Budget, error) {
+	b := &Budget{checker: checker, reserve: reserve}
+	if err := b.check(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Unlimited reports whether the registry reported no limit.
+func (b *Budget) Unlimited() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.unlimited
+}
+
+// Available returns the pulls which can currently be taken.
+func (b *Budget) Available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available()
+}
+
+// Take takes a pull from the budget and reports whether one was left. The limit is read again every
+// few pulls and, at most every recheckInterval, once the budget ran out. Only one Take reads it at a time,
+// without holding up the others.
+func (b *Budget) Take(ctx context.Context) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.unlimited {
+		return true
+	}
+	if !b.checking && (b.taken >= recheckAfter || (b.available() <= 0 && time.Since(b.checkedAt) >= recheckInterval)) {
+		b.recheck(ctx)
+	}
+	if b.unlimited {
+		return true
+	}
+	if b.available() <= 0 {
+		return false
+	}
+	b.remaining--
+	b.taken++
+	return true
+}
+
+// recheck reads the limit again without holding mu, the caller holds it. Pulls taken meanwhile are
+// counted against the limit read. When it can not be read, the local count goes on until the next recheck.
+func (b *Budget) recheck(ctx context.Context) {
+	b.checking = true
+	takenBefore := b.taken
+	b.mu.Unlock()
+
+	limit, err := b.checker.Check(ctx)
+
+	b.mu.Lock()
+	b.checking = false
+	takenSince := b.taken - takenBefore
+	b.checkedAt, b.taken = time.Now(), takenSince
+	if err != nil {
+		log.Warnf("could not read the docker hub rate limit again, keep counting: %v", err)
+		return
+	}
+	b.apply(limit)
+	b.remaining -= takenSince
+}
+
+func (b *Budget) available() int {
+	return b.remaining - b.reserve
+}
+
+// check reads the limit of a budget which is not shared yet.
+func (b *Budget) check(ctx context.Context) error {
+	limit, err := b.checker.Check(ctx)
+	if err != nil {
+		return err
+	}
+	b.checkedAt, b.taken = time.Now(), 0
+	b.apply(limit)
+	return nil
+}
+
+// apply sets the pulls left to those of limit, nil when the registry reported no limit.
+func (b *Budget) apply(limit *Limit) {
+	if limit == nil {
+		b.unlimited = true
+		return
+	}
+	b.remaining = limit.Remaining
+	log.WithField("ratelimit_source", limit.Source).Debugf("docker hub rate limit: %s", limit)
+}
@@ -0,0 +1,175 @@
+// Package ratelimit reads the Docker Hub pull rate limit and budgets the pulls a sync may spend.
+//
+// Docker Hub counts GET requests of manifests as pulls and reports the limit in the ratelimit-limit and
+// ratelimit-remaining headers, HEAD requests are answered with the same headers but are not counted.
+package ratelimit
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/retry"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/types"
+)
+
+// Docker Hub endpoints queried by NewDockerHubChecker. The preview repository is documented by Docker
+// for reading the rate limit without spending a pull.
+const (
+	dockerHubAuthURL     = "https://auth.docker.io/token"
+	dockerHubDomain      = "docker.io"
+	dockerHubRegistryURL = "https://registry-1.docker.io"
+	previewRepository    = "ratelimitpreview/test"
+)
+
+const requestTimeout = 30 * time.Second
+
+// Limit is the pull rate limit reported by a registry.
+type Limit struct {
+	Limit     int           // Pulls allowed per Window
+	Remaining int           // Pulls left in the current Window
+	Source    string        // Account or IP address the limit applies to, when reported
+	Window    time.Duration // Sliding window of the limit
+}
+
+func (l *Limit) String() string {
+	return fmt.Sprintf("%d of %d pulls per %s remaining", l.Remaining, l.Limit, l.Window)
+}
+
+// Checker reads the current rate limit of a registry.
+type Checker struct {
+	AuthURL     string // Token endpoint
+	Client      *http.Client
+	Credentials *types.DockerAuthConfig // Optional, the limit of anonymous pulls applies without
+	RegistryURL string                  // Registry API base URL
+	Repository  string                  // Repository whose manifest is requested with HEAD
+}
+
+// NewDockerHubChecker returns a Checker of the Docker Hub pull limit for credentials, nil checks the anonymous limit.
+func NewDockerHubChecker(credentials *types.DockerAuthConfig) *Checker {
+	return &Checker{
+		AuthURL:     dockerHubAuthURL,
+		Client:      &http.Client{Timeout: requestTimeout},
+		Credentials: credentials,
+		RegistryURL: dockerHubRegistryURL,
+		Repository:  previewRepository,
+	}
+}
+
+// IsDockerHub reports whether image is pulled from Docker Hub, e.g. alpine, library/alpine or docker.io/library/alpine.
+func IsDockerHub(image string) bool {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	return reference.Domain(named) == dockerHubDomain
+}
+
+// Check returns the current limit, nil when the registry reports none, e.g. for accounts without a pull limit.
+func (c *Checker) Check(ctx context.Context) (*Limit, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("%s/v2/%s/manifests/latest", c.RegistryURL, c.Repository), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the rate limit: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("could not read the rate limit: %w", retry.NewStatusError(res))
+	}
+
+	return parseLimit(res.Header)
+}
+
+// token returns a bearer token allowed to pull the preview repository.
+func (c *Checker) token(ctx context.Context) (string, error) {
+	query := url.Values{}
+	query.Set("service", "registry.docker.io")
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", c.Repository))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.AuthURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.Credentials != nil && c.Credentials.Username != "" {
+		req.SetBasicAuth(c.Credentials.Username, c.Credentials.Password)
+	}
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not authenticate to read the rate limit: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not authenticate to read the rate limit: %w", retry.NewStatusError(res))
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		Token       string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("could not decode the registry token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseLimit reads the ratelimit-limit and ratelimit-remaining headers, e.g. "100;w=21600".
+func parseLimit(header http.Header) (*Limit, error) {
+	limitHeader, remainingHeader := header.Get("ratelimit-limit"), header.Get("ratelimit-remaining")
+	if limitHeader == "" || remainingHeader == "" {
+		return nil, nil
+	}
+
+	limit, window, err := parseQuota(limitHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratelimit-limit %q: %w", limitHeader, err)
+	}
+	remaining, _, err := parseQuota(remainingHeader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratelimit-remaining %q: %w", remainingHeader, err)
+	}
+
+	source := header.Get("docker-ratelimit-source")
+	return &Limit{Limit: limit, Remaining: remaining, Source: source, Window: window}, nil
+}
+
+// parseQuota parses a "COUNT;w=SECONDS" quota.
+func parseQuota(value string) (int, time.Duration, error) {
+	parts := strings.Split(value, ";")
+	count, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var window time.Duration
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "w=") {
+			seconds, err := strconv.Atoi(strings.TrimPrefix(part, "w="))
+			if err != nil {
+				return 0, 0, err
+			}
+			window = time.Duration(seconds) * time.Second
+		}
+	}
+	return count, window, nil
+}
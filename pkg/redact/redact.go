@@ -0,0 +1,146 @@
+// Package redact masks credentials before they reach logs, tables, reports, events or notifications.
+package redact
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Mask replaces every redacted value.
+const Mask = "*****"
+
+// minSecretLength keeps very short values from masking unrelated text, they are only caught by the patterns below.
+const minSecretLength = 4
+
+var (
+	mu      sync.RWMutex
+	secrets []string // registered secrets, longest first so a secret is never partially masked by a shorter one
+
+	// patterns catch credentials which were never registered, e.g. tokens echoed back by a registry.
+	patterns = []struct {
+		re          *regexp.Regexp
+		replacement string
+	}{
+		// Authorization header values
+		{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`), "$1 " + Mask},
+		// user:password@ in URLs
+		{regexp.MustCompile(`(://[^/\s:@]+):[^/\s@]+@`), "$1:" + Mask + "@"},
+		// JSON encoded credentials, e.g. token responses or docker auth files
+		{regexp.MustCompile(`(?i)("(?:password|secret|token|access_token|registrytoken|authorizationtoken|auth)"\s*:\s*")[^"]*`), "${1}" + Mask},
+		// query and form encoded credentials, including presigned AWS requests
+		{regexp.MustCompile(`(?i)\b((?:password|secret|token|access_token|X-Amz-Security-Token|X-Amz-Signature|X-Amz-Credential)=)[^&\s"]+`), "${1}" + Mask},
+	}
+)
+
+// Register adds secret values which are masked wherever they appear. Values shorter than minSecretLength are
+// not registered, a warning is logged for them.
+func Register(values ...string) {
+	short := 0
+
+	mu.Lock()
+	for _, value := range values {
+		if len(value) < minSecretLength {
+			if value != "" {
+				short++
+			}
+			continue
+		}
+		if contains(secrets, value) {
+			continue
+		}
+		secrets = append(secrets, value)
+	}
+	sort.SliceStable(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	mu.Unlock()
+
+	// Logged once the lock is released, the Formatter redacts the entry with String.
+	if short > 0 {
+		logrus.Warnf("%d secret(s) shorter than %d characters are not redacted, use longer passwords and tokens", short, minSecretLength)
+	}
+}
+
+// RegisterCredentials registers the password of a USERNAME[:PASSWORD] pair, such as --src-creds or a decoded ECR token.
+func RegisterCredentials(creds string) {
+	if i := strings.Index(creds, ":"); i >= 0 {
+		Register(creds[i+1:])
+	}
+}
+
+// String returns s with registered secrets and known credential patterns masked.
+func String(s string) string {
+	mu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	mu.RUnlock()
+
+	for _, p := range patterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// Error returns the redacted message of err, or an empty string when err is nil. The urls of failed requests
+// wrapped in err keep only their scheme and host, webhook and gateway urls often carry a token in their path or query.
+func Error(err error) string {
+	if err == nil {
+		return ""
+	}
+	message := err.Error()
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if urlErr, ok := e.(*url.Error); ok && urlErr.URL != "" {
+			message = strings.ReplaceAll(message, urlErr.URL, requestURL(urlErr.URL))
+		}
+	}
+	return String(message)
+}
+
+// requestURL masks everything but the scheme and host of rawURL.
+func requestURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return Mask
+	}
+	if u.User == nil && (u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == "" {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host + "/" + Mask
+}
+
+// Formatter wraps a logrus.Formatter and masks the message and string or error fields of every entry.
+type Formatter struct {
+	logrus.Formatter
+}
+
+func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = String(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case error:
+			redacted.Data[key] = Error(value)
+		case string:
+			redacted.Data[key] = String(value)
+		default:
+			redacted.Data[key] = value
+		}
+	}
+	return f.Formatter.Format(&redacted)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ValidFormat reports whether format is a known report format.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatJSON, FormatJUnit, FormatMarkdown:
+		return true
+	}
+	return false
+}
+
+// Write renders r to w in the given format.
+func Write(w io.Writer, format string, r *Report) error {
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, r)
+	case FormatJUnit:
+		return writeJUnit(w, r)
+	case FormatMarkdown:
+		return writeMarkdown(w, r)
+	default:
+		return fmt.Errorf("unknown report format %q, expected one of %s, %s or %s", format, FormatJSON, FormatJUnit, FormatMarkdown)
+	}
+}
+
+// WriteFile renders r to path in the given format, "-" writes to stdout.
+func WriteFile(path, format string, r *Report) error {
+	if path == "" || path == "-" {
+		return Write(os.Stdout, format, r)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create report file: %w", err)
+	}
+	if err := Write(f, format, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile reads a report written in the json format from path.
+func ReadFile(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read report: %w", err)
+	}
+	defer f.Close()
+
+	var r Report
+	if err := json.NewDecoder(f).Decode(&r); err != nil {
+		return nil, fmt.Errorf("could not decode report %s, expected the %s format: %w", path, FormatJSON, err)
+	}
+	if r.Command == "" {
+		return nil, fmt.Errorf("%s is not a report in the %s format", path, FormatJSON)
+	}
+	return &r, nil
+}
+
+// MarshalJSON adds the run duration in seconds.
+func (r Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		report
+		DurationSeconds float64 `json:"durationSeconds"`
+	}{report(r), r.Duration.Seconds()})
+}
+
+// MarshalJSON adds the record duration in seconds.
+func (r Record) MarshalJSON() ([]byte, error) {
+	type record Record
+	return json.Marshal(struct {
+		record
+		DurationSeconds float64 `json:"durationSeconds"`
+	}{record(r), r.Duration.Seconds()})
+}
+
+func writeJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Failures  int             `xml:"failures,attr"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+}
+
+type junitTestCase struct {
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Name      string        `xml:"name,attr"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	Time      string        `xml:"time,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit renders one test case per record, failed records become test failures.
+func writeJUnit(w io.Writer, r *Report) error {
+	suite := junitTestSuite{
+		Failures:  r.Totals.Failed,
+		Name:      "ecr-mirror-sync " + r.Command,
+		Tests:     len(r.Records),
+		Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+		Timestamp: r.StartedAt.UTC().Format("2006-01-02T15:04:05"),
+	}
+
+	for _, record := range r.Records {
+		testCase := junitTestCase{
+			Classname: record.Source,
+			Name:      fmt.Sprintf("%s:%s", record.Destination, record.Tag),
+			SystemOut: fmt.Sprintf("action=%s upstreamDigest=%s ecrDigest=%s bytesTransferred=%d",
+				record.Action, record.UpstreamDigest, record.ECRDigest, record.BytesTransferred),
+			Time: fmt.Sprintf("%.3f", record.Duration.Seconds()),
+		}
+		if record.Error != "" {
+			testCase.Failure = &junitFailure{Message: record.Error, Text: record.Error}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func writeMarkdown(w io.Writer, r *Report) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# ecr-mirror-sync %s\n\n", r.Command)
+	fmt.Fprintf(&b, "Started %s, took %s.\n\n", r.StartedAt.UTC().Format("2006-01-02 15:04:05 MST"), r.Duration.Round(time.Millisecond))
+	if r.Interrupted {
+		fmt.Fprintf(&b, "**Interrupted**, only the images below were handled.\n\n")
+	}
+	fmt.Fprintf(&b, "| Total | Processed | Succeeded | Failed |\n|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d |\n\n", r.Totals.Total, r.Totals.Processed, r.Totals.Succeeded, r.Totals.Failed)
+	fmt.Fprintf(&b, "| Source | Destination | Tag | Action | Upstream Digest | ECR Digest | Duration | Bytes | Error |\n")
+	fmt.Fprintf(&b, "|---|---|---|---|---|---|---|---|---|\n")
+
+	for _, record := range r.Records {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %d | %s |\n",
+			markdownCell(record.Source),
+			markdownCell(record.Destination),
+			markdownCell(record.Tag),
+			record.Action,
+			markdownCell(record.UpstreamDigest),
+			markdownCell(record.ECRDigest),
+			record.Duration.Round(time.Millisecond),
+			record.BytesTransferred,
+			markdownCell(record.Error),
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes a value for use inside a markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
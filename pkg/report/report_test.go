@@ -0,0 +1,237 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() *Report {
+	return &Report{
+		Command:     "sync",
+		Duration:    90 * time.Second,
+		Interrupted: true,
+		Records: []Record{
+			{
+				Action:           "copied",
+				BytesTransferred: 3 << 20,
+				Changed:          true,
+				ChangedSinceLast: true,
+				Destination:      "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine",
+				Duration:         1500 * time.Millisecond,
+				ECRDigest:        "sha256:0123",
+				PreviousDigest:   "sha256:abcd",
+				Source:           "alpine",
+				Tag:              "3.17",
+				UpstreamDigest:   "sha256:0123",
+			},
+			{
+				Action:      "failed",
+				Destination: "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/nginx",
+				Error:       "reading manifest 1.23 | denied\nretry later",
+				Source:      "nginx",
+				Tag:         "1.23",
+			},
+		},
+		RunID:     "0f8fad5b-d9cb-469f-a165-70867728950e",
+		StartedAt: time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC),
+		Totals:    Totals{Failed: 1, Processed: 2, Succeeded: 1, Total: 3},
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatJSON, testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Command         string                   `json:"command"`
+		DurationSeconds float64                  `json:"durationSeconds"`
+		Interrupted     bool                     `json:"interrupted"`
+		Records         []map[string]interface{} `json:"records"`
+		RunID           string                   `json:"runId"`
+		StartedAt       string                   `json:"startedAt"`
+		Totals          map[string]int           `json:"totals"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("invalid json %s: %v", out.String(), err)
+	}
+	if got.Command != "sync" || got.DurationSeconds != 90 || !got.Interrupted || got.RunID != "0f8fad5b-d9cb-469f-a165-70867728950e" || got.StartedAt != "2022-01-01T12:00:00Z" {
+		t.Errorf("report = %+v", got)
+	}
+	if got.Totals["failed"] != 1 || got.Totals["processed"] != 2 || got.Totals["succeeded"] != 1 || got.Totals["total"] != 3 {
+		t.Errorf("totals = %v", got.Totals)
+	}
+	if len(got.Records) != 2 {
+		t.Fatalf("wrote %d records, want 2", len(got.Records))
+	}
+
+	copied := got.Records[0]
+	for field, want := range map[string]interface{}{
+		"action":              "copied",
+		"bytesTransferred":    float64(3 << 20),
+		"changed":             true,
+		"changedSinceLastRun": true,
+		"destination":         "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine",
+		"durationSeconds":     1.5,
+		"ecrDigest":           "sha256:0123",
+		"previousDigest":      "sha256:abcd",
+		"source":              "alpine",
+		"tag":                 "3.17",
+		"upstreamDigest":      "sha256:0123",
+	} {
+		if copied[field] != want {
+			t.Errorf("record %s = %v, want %v", field, copied[field], want)
+		}
+	}
+	if _, ok := copied["error"]; ok {
+		t.Error("the copied record has an error field")
+	}
+	if _, ok := copied["Duration"]; ok {
+		t.Error("the record duration is written as nanoseconds")
+	}
+
+	failed := got.Records[1]
+	for _, field := range []string{"ecrDigest", "previousDigest", "upstreamDigest"} {
+		if _, ok := failed[field]; ok {
+			t.Errorf("the failed record has an empty %s field", field)
+		}
+	}
+	if failed["error"] != "reading manifest 1.23 | denied\nretry later" {
+		t.Errorf("record error = %v", failed["error"])
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatJUnit, testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), xml.Header) {
+		t.Errorf("junit report does not start with the xml header:\n%s", out.String())
+	}
+
+	var got junitTestSuites
+	if err := xml.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatalf("invalid xml %s: %v", out.String(), err)
+	}
+	if len(got.Suites) != 1 {
+		t.Fatalf("wrote %d test suites, want 1", len(got.Suites))
+	}
+	suite := got.Suites[0]
+	if suite.Name != "ecr-mirror-sync sync" || suite.Tests != 2 || suite.Failures != 1 || suite.Time != "90.000" || suite.Timestamp != "2022-01-01T12:00:00" {
+		t.Errorf("test suite = %+v", suite)
+	}
+	if len(suite.TestCases) != 2 {
+		t.Fatalf("wrote %d test cases, want 2", len(suite.TestCases))
+	}
+
+	copied, failed := suite.TestCases[0], suite.TestCases[1]
+	if copied.Classname != "alpine" || copied.Name != "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine:3.17" || copied.Time != "1.500" || copied.Failure != nil {
+		t.Errorf("copied test case = %+v", copied)
+	}
+	if want := "action=copied upstreamDigest=sha256:0123 ecrDigest=sha256:0123 bytesTransferred=3145728"; copied.SystemOut != want {
+		t.Errorf("system-out = %q, want %q", copied.SystemOut, want)
+	}
+	if failed.Failure == nil || failed.Failure.Message != testReport().Records[1].Error {
+		t.Errorf("failed test case = %+v, want a failure with the error", failed)
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var out strings.Builder
+	if err := Write(&out, FormatMarkdown, testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		"# ecr-mirror-sync sync",
+		"Started 2022-01-01 12:00:00 UTC, took 1m30s.",
+		"**Interrupted**, only the images below were handled.",
+		"| 3 | 2 | 1 | 1 |",
+		"| alpine | 123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine | 3.17 | copied | sha256:0123 | sha256:0123 | 1.5s | 3145728 |  |",
+		`| nginx | 123456789012.dkr.ecr.us-east-1.amazonaws.com/external/nginx | 1.23 | failed |  |  | 0s | 0 | reading manifest 1.23 \| denied retry later |`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("markdown report is missing the line %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if ValidFormat("yaml") {
+		t.Error("yaml is a valid format")
+	}
+	for _, format := range []string{FormatJSON, FormatJUnit, FormatMarkdown} {
+		if !ValidFormat(format) {
+			t.Errorf("%s is not a valid format", format)
+		}
+	}
+	if err := Write(io.Discard, "yaml", testReport()); err == nil {
+		t.Error("Write of an unknown format succeeded")
+	}
+}
+
+func TestWriteFileAndReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteFile(path, FormatJSON, testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testReport()
+	// The durations are written in seconds only, ReadFile does not restore them.
+	want.Duration, want.Records[0].Duration = 0, 0
+	if !got.StartedAt.Equal(want.StartedAt) {
+		t.Errorf("read started at %s, want %s", got.StartedAt, want.StartedAt)
+	}
+	got.StartedAt = want.StartedAt
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("read %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestReadFileRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"junit.xml":  xml.Header + "<testsuites></testsuites>",
+		"empty.json": "{}",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadFile(path); err == nil {
+			t.Errorf("ReadFile of %s succeeded", name)
+		}
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadFile of a missing file succeeded")
+	}
+}
+
+func TestChangedSinceLastRun(t *testing.T) {
+	rep := testReport()
+	changed := rep.ChangedSinceLastRun()
+
+	if len(changed.Records) != 1 || changed.Records[0].Source != "alpine" {
+		t.Errorf("changed records = %+v, want alpine only", changed.Records)
+	}
+	if changed.Totals != rep.Totals {
+		t.Errorf("totals = %+v, want the ones of the whole run", changed.Totals)
+	}
+	if len(rep.Records) != 2 {
+		t.Error("ChangedSinceLastRun modified the report")
+	}
+}
@@ -0,0 +1,58 @@
+package report
+
+import "time"
+
+// Report formats accepted by --report-format.
+const (
+	FormatJSON     = "json"
+	FormatJUnit    = "junit"
+	FormatMarkdown = "markdown"
+)
+
+// Report is the machine readable summary of a list, sync or copy run.
+type Report struct {
+	Command     string        `json:"command"`
+	Duration    time.Duration `json:"-"`
+	Interrupted bool          `json:"interrupted,omitempty"` // the run was stopped before every image was handled
+	Records     []Record      `json:"records"`
+	RunID       string        `json:"runId,omitempty"`
+	StartedAt   time.Time     `json:"startedAt"`
+	Totals      Totals        `json:"totals"`
+}
+
+// Record describes the handling of a single upstream image:tag.
+type Record struct {
+	Action           string        `json:"action"`
+	BytesTransferred uint64        `json:"bytesTransferred"`
+	Changed          bool          `json:"changed"`             // the image was copied into ECR
+	ChangedSinceLast bool          `json:"changedSinceLastRun"` // the upstream digest differs from the previous run's
+	Destination      string        `json:"destination"`
+	Duration         time.Duration `json:"-"`
+	ECRDigest        string        `json:"ecrDigest,omitempty"`
+	Error            string        `json:"error,omitempty"`
+	PreviousDigest   string        `json:"previousDigest,omitempty"` // upstream digest seen by the previous run
+	Source           string        `json:"source"`
+	Tag              string        `json:"tag"`
+	UpstreamDigest   string        `json:"upstreamDigest,omitempty"`
+}
+
+// ChangedSinceLastRun returns a copy of r holding only the records whose upstream digest changed since the previous run.
+// Totals still describe the whole run.
+func (r *Report) ChangedSinceLastRun() *Report {
+	changed := *r
+	changed.Records = nil
+	for _, record := range r.Records {
+		if record.ChangedSinceLast {
+			changed.Records = append(changed.Records, record)
+		}
+	}
+	return &changed
+}
+
+// Totals holds the aggregated counts of a run.
+type Totals struct {
+	Failed    int `json:"failed"`
+	Processed int `json:"processed"`
+	Succeeded int `json:"succeeded"`
+	Total     int `json:"total"`
+}
@@ -0,0 +1,115 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	errcodev2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/hashicorp/go-multierror"
+)
+
+// registryStatus matches the status code in the errors of unexpected registry responses which carry no typed error.
+var registryStatus = regexp.MustCompile(`(?:StatusCode: |invalid status code from registry )(\d{3})`)
+
+// Retryable reports whether the operation which failed with err may succeed when attempted again.
+// Canceled operations, authentication and authorization failures and missing images or repositories are never
+// retried. Throttling, server errors and network failures are.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch e := err.(type) {
+	case docker.ErrUnauthorizedForCredentials:
+		return false
+	case *StatusError:
+		return retryableStatus(e.StatusCode)
+	case errcode.Error:
+		switch e.Code {
+		case errcode.ErrorCodeUnauthorized, errcode.ErrorCodeDenied, errcode.ErrorCodeUnsupported,
+			errcodev2.ErrorCodeNameUnknown, errcodev2.ErrorCodeNameInvalid,
+			errcodev2.ErrorCodeManifestUnknown, errcodev2.ErrorCodeBlobUnknown:
+			return false
+		}
+		return true
+	case errcode.Errors:
+		return allRetryable(e)
+	case *multierror.Error:
+		return allRetryable(e.Errors)
+	case awserr.RequestFailure:
+		return retryableStatus(e.StatusCode()) || request.IsErrorThrottle(e)
+	case awserr.Error:
+		return request.IsErrorRetryable(e) || request.IsErrorThrottle(e)
+	case *url.Error:
+		if e.Err == io.EOF || errors.Is(e.Err, io.ErrUnexpectedEOF) {
+			return true // the server closed the connection
+		}
+		return Retryable(e.Err)
+	case *net.OpError:
+		return Retryable(e.Err)
+	case syscall.Errno:
+		return retryableErrno(e)
+	}
+
+	if errors.Is(err, docker.ErrTooManyRequests) {
+		return true
+	}
+	if match := registryStatus.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return retryableStatus(code)
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if next := errors.Unwrap(err); next != nil {
+		return Retryable(next)
+	}
+	if cause, ok := err.(interface{ Cause() error }); ok && cause.Cause() != err {
+		return Retryable(cause.Cause())
+	}
+	return false
+}
+
+// RetryAfter returns the delay requested by the server which failed with err, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	var requested interface{ RetryAfter() time.Duration }
+	if errors.As(err, &requested) && requested.RetryAfter() > 0 {
+		return requested.RetryAfter(), true
+	}
+	return 0, false
+}
+
+func allRetryable(errs []error) bool {
+	for _, err := range errs {
+		if !Retryable(err) {
+			return false
+		}
+	}
+	return len(errs) > 0
+}
+
+// retryableStatus reports whether a request answered with code may succeed later: throttling, timeouts and server errors.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= http.StatusInternalServerError
+}
+
+func retryableErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.ECONNREFUSED, syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ENETDOWN, syscall.ENETUNREACH,
+		syscall.ENETRESET, syscall.ECONNABORTED, syscall.ECONNRESET, syscall.ETIMEDOUT, syscall.EHOSTDOWN, syscall.EHOSTUNREACH:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+// Package retry retries registry and AWS operations with exponential backoff and jitter, as long as their errors are transient.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configures how often and how patiently an operation is retried.
+type Options struct {
+	Delay       time.Duration // Delay before the first retry, doubled after every further attempt
+	MaxDelay    time.Duration // Upper bound of the delay between two attempts
+	MaxRetry    int           // Number of retries after the first attempt, 0 never retries
+	MaxRetrySet bool          // MaxRetry was configured, else AWS API calls keep the retries of the SDK
+}
+
+// Do runs operation and retries it while it fails with a retryable error, see Retryable, nil opts never retries.
+// A delay requested with Retry-After by a StatusError or an AWS API call is honored, otherwise the delay grows exponentially
+// with jitter. The last error is returned once the retries are exhausted or ctx is done.
+func Do(ctx context.Context, opts *Options, operation func() error) error {
+	err := operation()
+	if opts == nil {
+		return err
+	}
+	for attempt := 0; err != nil && Retryable(err) && attempt < opts.MaxRetry; attempt++ {
+		delay := opts.backoff(attempt)
+		if after, ok := RetryAfter(err); ok {
+			delay = after
+		}
+
+		log.WithField("error", err).Warnf("retrying in %s (%d/%d)", delay.Round(time.Millisecond), attempt+1, opts.MaxRetry)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		err = operation()
+	}
+	return err
+}
+
+// backoff returns the delay before retry number attempt: half of the exponential delay plus a random
+// share of the other half, so concurrent workers do not retry in lockstep.
+func (opts *Options) backoff(attempt int) time.Duration {
+	delay := opts.Delay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for i := 0; i < attempt && (opts.MaxDelay <= 0 || delay < opts.MaxDelay); i++ {
+		delay *= 2
+	}
+	if opts.MaxDelay > 0 && delay > opts.MaxDelay {
+		delay = opts.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// AWSRetryer returns the retryer of AWS API calls. It retries throttled and failed calls as often as
+// opts, or as often as the SDK does by default when MaxRetry was not configured, and honors Retry-After
+// on throttling, see AWSRetryAfter.
+func (opts *Options) AWSRetryer() request.Retryer {
+	retries := client.DefaultRetryerMaxNumRetries
+	if opts.MaxRetrySet {
+		retries = opts.MaxRetry
+	}
+	return awsRetryer{client.DefaultRetryer{
+		NumMaxRetries:    retries,
+		MinRetryDelay:    opts.Delay,
+		MaxRetryDelay:    opts.MaxDelay,
+		MinThrottleDelay: opts.Delay,
+		MaxThrottleDelay: opts.MaxDelay,
+	}}
+}
+
+// awsRetryer waits as long as the Retry-After kept by AWSRetryAfter asks, in seconds or as an HTTP date,
+// before retrying a call. The SDK only reads the seconds.
+type awsRetryer struct {
+	client.DefaultRetryer
+}
+
+func (r awsRetryer) RetryRules(req *request.Request) time.Duration {
+	if delay, ok := RetryAfter(req.Error); ok {
+		return delay
+	}
+	return r.DefaultRetryer.RetryRules(req)
+}
+
+// AWSRetryAfter keeps the delay requested with Retry-After by a failed AWS API call in its error, so that
+// the SDK's retries and Do honor it. It is an UnmarshalError handler, the error it wraps still is an
+// awserr.RequestFailure.
+var AWSRetryAfter = request.NamedHandler{Name: "retry.AWSRetryAfter", Fn: func(r *request.Request) {
+	if r.HTTPResponse == nil {
+		return
+	}
+	failure, ok := r.Error.(awserr.RequestFailure)
+	if !ok {
+		return
+	}
+	if delay := parseRetryAfter(r.HTTPResponse.Header.Get("Retry-After")); delay > 0 {
+		r.Error = &awsRetryAfterError{RequestFailure: failure, retry: delay}
+	}
+}}
+
+// awsRetryAfterError is a failed AWS API call which carries the delay requested with Retry-After.
+type awsRetryAfterError struct {
+	awserr.RequestFailure
+	retry time.Duration
+}
+
+// RetryAfter returns the delay requested by AWS.
+func (e *awsRetryAfterError) RetryAfter() time.Duration {
+	return e.retry
+}
+
+// StatusError is an unexpected HTTP response, it carries the delay requested with Retry-After, if any.
+type StatusError struct {
+	Retry      time.Duration // Delay requested by the Retry-After header, 0 when none was sent
+	Status     string
+	StatusCode int
+	URL        string
+}
+
+// NewStatusError returns the error for an unexpected response res.
+func NewStatusError(res *http.Response) *StatusError {
+	err := &StatusError{
+		Retry:      parseRetryAfter(res.Header.Get("Retry-After")),
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+	}
+	if res.Request != nil && res.Request.URL != nil {
+		err.URL = res.Request.URL.Redacted()
+	}
+	return err
+}
+
+func (e *StatusError) Error() string {
+	if e.URL == "" {
+		return "unexpected response " + e.Status
+	}
+	return "unexpected response " + e.Status + " from " + e.URL
+}
+
+// RetryAfter returns the delay requested by the server.
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.Retry
+}
+
+// parseRetryAfter parses a Retry-After header holding either seconds or an HTTP date, 0 when it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && time.Until(at) > 0 {
+		return time.Until(at)
+	}
+	return 0
+}
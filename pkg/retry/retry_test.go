@@ -0,0 +1,215 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	errcodev2 "github.com/docker/distribution/registry/api/v2"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"canceled", fmt.Errorf("copy: %w", context.Canceled), false},
+		{"unauthorized for credentials", docker.ErrUnauthorizedForCredentials{Err: errors.New("denied")}, false},
+		{"registry unauthorized", errcode.ErrorCodeUnauthorized.WithMessage("authentication required"), false},
+		{"registry denied", errcode.Errors{errcode.ErrorCodeDenied.WithMessage("requested access to the resource is denied")}, false},
+		{"manifest unknown", errcodev2.ErrorCodeManifestUnknown.WithMessage("manifest unknown"), false},
+		{"repository unknown", errcodev2.ErrorCodeNameUnknown.WithMessage("repository name not known to registry"), false},
+		{"status 401", &StatusError{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}, false},
+		{"status 403", &StatusError{Status: "403 Forbidden", StatusCode: http.StatusForbidden}, false},
+		{"status 404", &StatusError{Status: "404 Not Found", StatusCode: http.StatusNotFound}, false},
+		{"registry status 404", errors.New("reading manifest 3.17 in docker.io/library/alpine: invalid status code from registry 404 (Not Found)"), false},
+		{"aws access denied", awserr.NewRequestFailure(awserr.New("AccessDeniedException", "not authorized", nil), http.StatusForbidden, "id"), false},
+		{"aws repository not found", awserr.NewRequestFailure(awserr.New("RepositoryNotFoundException", "does not exist", nil), http.StatusBadRequest, "id"), false},
+		{"too many requests", fmt.Errorf("copying image: %w", docker.ErrTooManyRequests), true},
+		{"status 429", &StatusError{Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}, true},
+		{"status 503", &StatusError{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}, true},
+		{"registry status 503", errors.New("pinging container registry: StatusCode: 503, <html>"), true},
+		{"registry unavailable", errcode.ErrorCodeUnavailable.WithMessage("service unavailable"), true},
+		{"aws throttling", awserr.NewRequestFailure(awserr.New("ThrottlingException", "rate exceeded", nil), http.StatusBadRequest, "id"), true},
+		{"aws 503", awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "unavailable", nil), http.StatusServiceUnavailable, "id"), true},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Retryable(c.err); got != c.want {
+				t.Errorf("Retryable(%v) = %t, want %t", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	opts := &Options{Delay: time.Millisecond, MaxDelay: 2 * time.Millisecond, MaxRetry: 3}
+
+	for _, c := range []struct {
+		name     string
+		opts     *Options
+		err      error
+		attempts int
+	}{
+		{"retryable", opts, &StatusError{Status: "503 Service Unavailable", StatusCode: http.StatusServiceUnavailable}, 4},
+		{"too many requests", opts, docker.ErrTooManyRequests, 4},
+		{"unauthorized", opts, &StatusError{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}, 1},
+		{"not found", opts, errcodev2.ErrorCodeManifestUnknown.WithMessage("manifest unknown"), 1},
+		{"nil options", nil, docker.ErrTooManyRequests, 1},
+		{"no retries", &Options{MaxRetry: 0}, docker.ErrTooManyRequests, 1},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			attempts := 0
+			err := Do(context.Background(), c.opts, func() error {
+				attempts++
+				return c.err
+			})
+			if err != c.err {
+				t.Errorf("Do returned %v, want %v", err, c.err)
+			}
+			if attempts != c.attempts {
+				t.Errorf("operation attempted %d times, want %d", attempts, c.attempts)
+			}
+		})
+	}
+}
+
+func TestDoStopsOnSuccess(t *testing.T) {
+	attempts := 0
+	err := Do(context.Background(), &Options{Delay: time.Millisecond, MaxRetry: 5}, func() error {
+		if attempts++; attempts < 3 {
+			return docker.ErrTooManyRequests
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Do returned %v after %d attempts, want success after 3", err, attempts)
+	}
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	throttled := &StatusError{Retry: 10 * time.Millisecond, Status: "429 Too Many Requests", StatusCode: http.StatusTooManyRequests}
+
+	start := time.Now()
+	attempts := 0
+	err := Do(context.Background(), &Options{Delay: time.Minute, MaxRetry: 1}, func() error {
+		if attempts++; attempts == 1 {
+			return throttled
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < throttled.Retry || elapsed > 10*time.Second {
+		t.Errorf("retried after %s, want the %s asked for by Retry-After", elapsed, throttled.Retry)
+	}
+}
+
+func TestDoStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := Do(ctx, &Options{Delay: time.Minute, MaxRetry: 3}, func() error {
+		attempts++
+		return docker.ErrTooManyRequests
+	})
+	if err != docker.ErrTooManyRequests || attempts != 1 {
+		t.Errorf("Do returned %v after %d attempts, want the first error once canceled", err, attempts)
+	}
+}
+
+func TestBackoffGrowsUpToMaxDelay(t *testing.T) {
+	opts := &Options{Delay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, full := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+		time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if delay := opts.backoff(attempt); delay < full/2 || delay > full {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", attempt, delay, full/2, full)
+			}
+		}
+	}
+
+	if delay := (&Options{Delay: time.Second, MaxDelay: 30 * time.Second}).backoff(100); delay > 30*time.Second {
+		t.Errorf("backoff(100) = %s, want at most the maximum delay", delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("parseRetryAfter(3) = %s, want 3s", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 50*time.Second || got > time.Minute {
+		t.Errorf("parseRetryAfter(%s) = %s, want about a minute", date, got)
+	}
+	for _, value := range []string{"", "-1", "soon", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %s, want 0", value, got)
+		}
+	}
+}
+
+func TestAWSRetryerKeepsTheSDKDefault(t *testing.T) {
+	for _, c := range []struct {
+		opts Options
+		want int
+	}{
+		{Options{}, client.DefaultRetryerMaxNumRetries},
+		{Options{MaxRetry: 0, MaxRetrySet: true}, 0},
+		{Options{MaxRetry: 7, MaxRetrySet: true}, 7},
+	} {
+		if got := c.opts.AWSRetryer().MaxRetries(); got != c.want {
+			t.Errorf("retryer of %+v retries %d times, want %d", c.opts, got, c.want)
+		}
+	}
+}
+
+func TestAWSRetryAfter(t *testing.T) {
+	throttled := awserr.NewRequestFailure(awserr.New("ThrottlingException", "rate exceeded", nil), http.StatusTooManyRequests, "id")
+	r := &request.Request{
+		Error:        throttled,
+		HTTPResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}},
+	}
+	AWSRetryAfter.Fn(r)
+
+	if delay, ok := RetryAfter(r.Error); !ok || delay != 2*time.Second {
+		t.Errorf("RetryAfter = %s, %t, want 2s", delay, ok)
+	}
+	if failure, ok := r.Error.(awserr.RequestFailure); !ok || failure.Code() != "ThrottlingException" || failure.StatusCode() != http.StatusTooManyRequests {
+		t.Errorf("the error %#v is no longer the request failure", r.Error)
+	}
+	if !Retryable(r.Error) || !request.IsErrorThrottle(r.Error) {
+		t.Error("the throttling error is not retried anymore")
+	}
+	if delay := (&Options{Delay: time.Millisecond, MaxDelay: time.Millisecond}).AWSRetryer().RetryRules(r); delay != 2*time.Second {
+		t.Errorf("RetryRules = %s, want the 2s asked for by Retry-After", delay)
+	}
+
+	unthrottled := &request.Request{Error: throttled, HTTPResponse: &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}}
+	AWSRetryAfter.Fn(unthrottled)
+	if unthrottled.Error != throttled {
+		t.Errorf("the error without Retry-After was replaced by %#v", unthrottled.Error)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrUnauthorized is returned by an Authenticator which rejects a request.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator decides whether a request may use the API.
+type Authenticator interface {
+	Authenticate(r *http.Request) error
+}
+
+// AllowAll accepts every request, it is used when no API token is configured.
+type AllowAll struct{}
+
+func (AllowAll) Authenticate(*http.Request) error {
+	return nil
+}
+
+// BearerTokens accepts requests carrying one of its tokens in an "Authorization: Bearer" header.
+type BearerTokens []string
+
+func (t BearerTokens) Authenticate(r *http.Request) error {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return ErrUnauthorized
+	}
+	presented := []byte(strings.TrimSpace(header[len("Bearer "):]))
+
+	for _, token := range t {
+		if subtle.ConstantTimeCompare(presented, []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return ErrUnauthorized
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerTokens(t *testing.T) {
+	tokens := BearerTokens{"first-token-value", "second-token-value"}
+
+	for _, c := range []struct {
+		header string
+		valid  bool
+	}{
+		{"Bearer first-token-value", true},
+		{"Bearer second-token-value", true},
+		{"bearer second-token-value ", true},
+		{"", false},
+		{"Bearer", false},
+		{"Bearer ", false},
+		{"Bearer third-token-value", false},
+		{"Bearer first-token", false},
+		{"Basic Zmlyc3QtdG9rZW4tdmFsdWU=", false},
+		{"first-token-value", false},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+
+		err := tokens.Authenticate(r)
+		if c.valid && err != nil {
+			t.Errorf("Authorization %q was rejected: %v", c.header, err)
+		}
+		if !c.valid && err != ErrUnauthorized {
+			t.Errorf("Authorization %q returned %v, want %v", c.header, err, ErrUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"ecr-mirror-sync/pkg/report"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxJobs bounds the finished jobs kept in memory, the oldest finished jobs are evicted first.
+const maxJobs = 1000
+
+// JobStatus is the state of an on-demand mirror request.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is an on-demand mirror of Source into the ECR repository Destination,
+// or a sync of the tags of Source which a registry webhook reported as pushed.
+type Job struct {
+	CreatedAt   time.Time      `json:"createdAt"`
+	Destination string         `json:"destination,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	FinishedAt  *time.Time     `json:"finishedAt,omitempty"`
+	ID          string         `json:"id"`
+	Report      *report.Report `json:"report,omitempty"`
+	Source      string         `json:"source"`
+	StartedAt   *time.Time     `json:"startedAt,omitempty"`
+	Status      JobStatus      `json:"status"`
+	Tags        []string       `json:"tags,omitempty"`
+}
+
+// jobStore keeps jobs in memory, jobs are lost when the process restarts.
+type jobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string // job IDs, oldest first
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: map[string]*Job{}}
+}
+
+// add stores job as a new queued job and returns a copy of it.
+func (s *jobStore) add(job Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.CreatedAt = time.Now().UTC()
+	job.ID = uuid.NewString()
+	job.Status = JobQueued
+
+	s.jobs[job.ID] = &job
+	s.order = append(s.order, job.ID)
+	s.evict()
+	return job
+}
+
+// get returns a copy of the job with id.
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// update applies fn to the job with id while holding the store lock.
+func (s *jobStore) update(id string, fn func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// remove drops the job with id, e.g. one which could not be queued.
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	for i, queued := range s.order {
+		if queued == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// evict drops the oldest finished jobs beyond maxJobs, queued and running jobs are always kept.
+func (s *jobStore) evict() {
+	excess := len(s.order) - maxJobs
+	if excess <= 0 {
+		return
+	}
+
+	kept := s.order[:0]
+	for _, id := range s.order {
+		job := s.jobs[id]
+		if excess > 0 && (job.Status == JobSucceeded || job.Status == JobFailed) {
+			delete(s.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestJobStoreEvictsTheOldestFinishedJobs(t *testing.T) {
+	store := newJobStore()
+
+	// The oldest job is still running, the next ones are finished and the last one is queued.
+	ids := make([]string, 0, maxJobs)
+	for i := 0; i < maxJobs; i++ {
+		ids = append(ids, store.add(Job{Source: "alpine:3.17"}).ID)
+	}
+	store.update(ids[0], func(job *Job) { job.Status = JobRunning })
+	for _, id := range ids[1 : maxJobs-1] {
+		store.update(id, func(job *Job) { job.Status = JobSucceeded })
+	}
+	store.update(ids[2], func(job *Job) { job.Status = JobFailed })
+
+	if len(store.jobs) != maxJobs {
+		t.Fatalf("kept %d jobs below the cap, want %d", len(store.jobs), maxJobs)
+	}
+
+	added := []string{store.add(Job{Source: "alpine:3.18"}).ID, store.add(Job{Source: "alpine:3.19"}).ID}
+
+	if len(store.jobs) != maxJobs || len(store.order) != maxJobs {
+		t.Errorf("kept %d jobs in an order of %d, want %d", len(store.jobs), len(store.order), maxJobs)
+	}
+	for _, id := range []string{ids[1], ids[2]} {
+		if _, ok := store.get(id); ok {
+			t.Errorf("the oldest finished job %s was kept", id)
+		}
+	}
+	for _, id := range append([]string{ids[0], ids[3], ids[maxJobs-1]}, added...) {
+		if _, ok := store.get(id); !ok {
+			t.Errorf("job %s was evicted", id)
+		}
+	}
+	if store.order[0] != ids[0] || store.order[len(store.order)-1] != added[1] {
+		t.Error("eviction changed the order of the jobs")
+	}
+}
+
+func TestJobStoreKeepsUnfinishedJobsBeyondTheCap(t *testing.T) {
+	store := newJobStore()
+	for i := 0; i < maxJobs+10; i++ {
+		store.add(Job{Source: "alpine:3.17"})
+	}
+	if len(store.jobs) != maxJobs+10 {
+		t.Errorf("kept %d queued jobs, want all %d", len(store.jobs), maxJobs+10)
+	}
+}
+
+func TestJobStoreRemove(t *testing.T) {
+	store := newJobStore()
+	first, second := store.add(Job{Source: "alpine:3.17"}), store.add(Job{Source: "alpine:3.18"})
+
+	store.remove(first.ID)
+	if _, ok := store.get(first.ID); ok || len(store.order) != 1 || store.order[0] != second.ID {
+		t.Errorf("remove kept %v, want only %s", store.order, second.ID)
+	}
+}
@@ -0,0 +1,324 @@
+// Package server exposes the mirror provider of the serve command over HTTP.
+package server
+
+import (
+	"bufio"
+	"context"
+	"ecr-mirror-sync/pkg/daemon"
+	"ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/redact"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxRequestBytes   = 1 << 20
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+)
+
+// Server serves the REST API and the health endpoints of the serve command.
+type Server struct {
+	auth      Authenticator
+	jobs      *jobStore
+	provider  Provider
+	pushes    *pushBatch // Pushes waiting for the queued webhook sync, nil when none is queued
+	pushesMu  sync.Mutex
+	queue     *daemon.Queue // Runs the jobs one at a time, alongside the scheduled syncs
+	readiness *readiness
+	status    *statusStore
+
+	webhookSecret string // Validates registry webhooks, empty disables the receiver
+}
+
+// Provider starts the runs of the API and checks the credentials for /readyz.
+type Provider interface {
+	CheckCredentials(ctx context.Context) error
+	NewRun() Run
+}
+
+// Run is a single run of the provider, it serves one request or job.
+type Run interface {
+	Copy(ctx context.Context, source, destination string) (*mirror.Result, error)
+	List(ctx context.Context) (*mirror.Result, error)
+	SyncMatching(ctx context.Context, match func(mirror.MirrorRepository) bool) (*mirror.Result, error)
+	Tagged(ctx context.Context, destination string) (bool, error)
+}
+
+// MirrorProvider is the Provider of a *mirror.MirrorProvider.
+type MirrorProvider struct {
+	*mirror.MirrorProvider
+}
+
+// NewRun returns a new run of the mirror provider.
+func (p MirrorProvider) NewRun() Run {
+	return p.MirrorProvider.NewRun()
+}
+
+// mirrorRequest is the body of POST /v1/mirrors.
+type mirrorRequest struct {
+	Destination string `json:"destination"` // Tagged ECR repository, e.g. 123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine
+	Source      string `json:"source"`      // upstream image:tag
+}
+
+// New returns a Server running the jobs it accepts with provider on queue.
+// A nil auth accepts every request, an empty webhookSecret disables the registry webhook receiver.
+func New(queue *daemon.Queue, provider Provider, auth Authenticator, webhookSecret string) *Server {
+	if auth == nil {
+		auth = AllowAll{}
+	}
+	return &Server{
+		auth:      auth,
+		jobs:      newJobStore(),
+		provider:  provider,
+		queue:     queue,
+		readiness: &readiness{check: provider.CheckCredentials},
+		status:    newStatusStore(),
+
+		webhookSecret: webhookSecret,
+	}
+}
+
+// Observe records the outcome of a run for /status, err is the error the run returned.
+func (s *Server) Observe(command string, result *mirror.Result, err error) {
+	s.status.observe(command, result, err)
+}
+
+// LoadBearerTokens reads one API token per line from path, blank lines and lines starting with # are ignored.
+func LoadBearerTokens(path string) (BearerTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read api tokens: %w", err)
+	}
+	defer f.Close()
+
+	var tokens BearerTokens
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		token := strings.TrimSpace(scanner.Text())
+		if token == "" || strings.HasPrefix(token, "#") {
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read api tokens: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no api tokens in %s", path)
+	}
+	redact.Register(tokens...)
+	return tokens, nil
+}
+
+// Handler returns the routes of the API. Probes are not authenticated, /status and the /v1 API are,
+// registry webhooks are validated by their own secret or signature.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.Handle("/status", s.authenticated(s.handleStatus))
+	mux.Handle("/v1/mirrors", s.authenticated(s.handleMirrors))
+	mux.Handle("/v1/jobs/", s.authenticated(s.handleJob))
+	mux.HandleFunc("/v1/webhooks/", s.handleWebhook)
+	return mux
+}
+
+// ListenAndServe serves the API on addr until ctx is done, then shuts the listener down gracefully.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("serving the api on %s", addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("api server failed: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// authenticated rejects requests which s.auth does not accept.
+func (s *Server) authenticated(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.auth.Authenticate(r); err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// handleHealthz reports that the process is alive.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz passes once the AWS credentials and the ECR authorization token are valid.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.readiness.ready(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// handleStatus returns the last sync and the last outcome of every repository and tag.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.status.status())
+}
+
+func (s *Server) handleMirrors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listMirrors(w, r)
+	case http.MethodPost:
+		s.createMirror(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// listMirrors returns the repositories and tags discovered by List as a json report.
+func (s *Server) listMirrors(w http.ResponseWriter, r *http.Request) {
+	result, err := s.provider.NewRun().List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result.Report("list"))
+}
+
+// createMirror queues a copy of the requested source into its destination and returns the job. Only the
+// repositories tagged for mirroring are accepted as destination, the copy pushes with the ECR credentials.
+func (s *Server) createMirror(w http.ResponseWriter, r *http.Request) {
+	var req mirrorRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Source == "" || req.Destination == "" {
+		writeError(w, http.StatusBadRequest, errors.New("source and destination are required"))
+		return
+	}
+
+	tagged, err := s.provider.NewRun().Tagged(r.Context(), req.Destination)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	if !tagged {
+		writeError(w, http.StatusForbidden, fmt.Errorf("destination %s is not a repository tagged for mirroring", req.Destination))
+		return
+	}
+
+	job := s.jobs.add(Job{Destination: req.Destination, Source: req.Source})
+	err = s.queue.Submit(func(ctx context.Context) {
+		s.runJobs(ctx, []string{job.ID}, "copy", func(ctx context.Context, run Run) (*mirror.Result, error) {
+			return run.Copy(ctx, job.Source, job.Destination)
+		})
+	})
+	if err != nil {
+		s.jobs.remove(job.ID)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"job_id":                   job.ID,
+		mirror.LogFieldSource:      job.Source,
+		mirror.LogFieldDestination: job.Destination,
+	}).Info("mirror requested")
+
+	s.writeJob(w, job)
+}
+
+// writeJob answers the request which queued job.
+func (s *Server) writeJob(w http.ResponseWriter, job Job) {
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// runJobs runs the jobs with ids as a single new run of the provider and records its outcome as command.
+func (s *Server) runJobs(ctx context.Context, ids []string, command string, run func(ctx context.Context, run Run) (*mirror.Result, error)) {
+	startedAt := time.Now().UTC()
+	for _, id := range ids {
+		s.jobs.update(id, func(job *Job) {
+			job.StartedAt = &startedAt
+			job.Status = JobRunning
+		})
+	}
+
+	result, err := run(ctx, s.provider.NewRun())
+
+	s.Observe(command, result, err)
+
+	finishedAt := time.Now().UTC()
+	for _, id := range ids {
+		s.jobs.update(id, func(job *Job) {
+			job.FinishedAt = &finishedAt
+			job.Status = JobSucceeded
+			if result != nil {
+				job.Report = result.Report(command)
+			}
+			if err != nil {
+				job.Error = redact.Error(err)
+				job.Status = JobFailed
+			}
+		})
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/jobs/")
+	job, ok := s.jobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %q not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("could not write api response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": redact.Error(err)})
+}
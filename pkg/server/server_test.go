@@ -0,0 +1,330 @@
+package server
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/daemon"
+	"ecr-mirror-sync/pkg/mirror"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testDestination = "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine"
+	testToken       = "api-token-value"
+)
+
+// fakeProvider serves runs without AWS or registries. Copy waits for release when it is set.
+type fakeProvider struct {
+	checkErr  error
+	checks    int
+	copied    []string
+	copyErr   error
+	mu        sync.Mutex
+	release   chan struct{}
+	syncs     int
+	synced    []string
+	tagged    map[string]bool
+	taggedErr error
+	upstream  []mirror.MirrorRepository // Mirrors matched by SyncMatching
+}
+
+func (p *fakeProvider) CheckCredentials(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks++
+	return p.checkErr
+}
+
+func (p *fakeProvider) NewRun() Run {
+	return p
+}
+
+func (p *fakeProvider) Copy(ctx context.Context, source, destination string) (*mirror.Result, error) {
+	if p.release != nil {
+		select {
+		case <-p.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.copied = append(p.copied, source+" "+destination)
+
+	repository := mirror.MirrorRepository{ECRRespository: destination, Outcome: mirror.OutcomeCopied, UpstreamImage: source, UpstreamTag: "3.17"}
+	if p.copyErr != nil {
+		repository.Err, repository.Outcome = p.copyErr, mirror.OutcomeFailed
+	}
+	return result("copy-run", repository), p.copyErr
+}
+
+func (p *fakeProvider) List(context.Context) (*mirror.Result, error) {
+	return result("list-run", mirror.MirrorRepository{ECRRespository: testDestination, UpstreamImage: "alpine", UpstreamTag: "3.17"}), nil
+}
+
+func (p *fakeProvider) SyncMatching(_ context.Context, match func(mirror.MirrorRepository) bool) (*mirror.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.syncs++
+	var matched []mirror.MirrorRepository
+	for _, m := range p.upstream {
+		if match(m) {
+			m.Outcome = mirror.OutcomeCopied
+			matched = append(matched, m)
+			p.synced = append(p.synced, m.UpstreamImage+":"+m.UpstreamTag)
+		}
+	}
+	return result("webhook-run", matched...), nil
+}
+
+func (p *fakeProvider) Tagged(_ context.Context, destination string) (bool, error) {
+	return p.tagged[destination], p.taggedErr
+}
+
+// result returns the result of a run handling repositories.
+func result(runID string, repositories ...mirror.MirrorRepository) *mirror.Result {
+	r := &mirror.Result{Repositories: repositories, RunID: runID, StartedAt: time.Now(), Total: len(repositories)}
+	for _, m := range repositories {
+		r.Processed++
+		if m.Outcome.Failed() {
+			r.Failed++
+		} else {
+			r.Succeeded++
+		}
+	}
+	return r
+}
+
+// newTestServer returns a server accepting testToken whose queue runs until the test ends, or until
+// startQueue is called when start is false.
+func newTestServer(t *testing.T, provider *fakeProvider, start bool) (*Server, func()) {
+	queue := daemon.NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var once sync.Once
+	startQueue := func() {
+		once.Do(func() { go queue.Start(ctx) })
+	}
+	if start {
+		startQueue()
+	}
+	return New(queue, provider, BearerTokens{testToken}, testWebhookSecret), startQueue
+}
+
+// do serves a request of method on target with body, authenticated with token unless it is empty.
+func do(s *Server, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// decode decodes the json body of w into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+}
+
+// waitForJob polls the job with id until it has status.
+func waitForJob(t *testing.T, s *Server, id string, status JobStatus) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job Job
+		decode(t, do(s, http.MethodGet, "/v1/jobs/"+id, testToken, ""), &job)
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, true)
+
+	for _, target := range []string{"/status", "/v1/mirrors", "/v1/jobs/unknown"} {
+		for _, token := range []string{"", "wrong-token", testToken + "x"} {
+			w := do(s, http.MethodGet, target, token, "")
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET %s with token %q answered %d, want %d", target, token, w.Code, http.StatusUnauthorized)
+			}
+			if w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("GET %s without a valid token does not ask for a bearer token", target)
+			}
+		}
+		if w := do(s, http.MethodGet, target, testToken, ""); w.Code == http.StatusUnauthorized {
+			t.Errorf("GET %s with a valid token answered %d", target, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.SetBasicAuth("admin", testToken)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /status with basic auth answered %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	for _, probe := range []string{"/healthz", "/readyz"} {
+		if w := do(s, http.MethodGet, probe, "", ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s without a token answered %d, want %d", probe, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestCreateMirror(t *testing.T) {
+	const untagged = "123456789012.dkr.ecr.us-east-1.amazonaws.com/private/app"
+	valid := `{"source":"alpine:3.17","destination":"` + testDestination + `"}`
+
+	for _, c := range []struct {
+		name      string
+		body      string
+		taggedErr error
+		want      int
+	}{
+		{"valid", valid, nil, http.StatusAccepted},
+		{"invalid json", `{"source":`, nil, http.StatusBadRequest},
+		{"unknown field", `{"source":"alpine:3.17","destination":"` + testDestination + `","force":true}`, nil, http.StatusBadRequest},
+		{"missing source", `{"destination":"` + testDestination + `"}`, nil, http.StatusBadRequest},
+		{"missing destination", `{"source":"alpine:3.17"}`, nil, http.StatusBadRequest},
+		{"too large", `{"source":"` + strings.Repeat("a", maxRequestBytes) + `","destination":"` + testDestination + `"}`, nil, http.StatusBadRequest},
+		{"untagged destination", `{"source":"alpine:3.17","destination":"` + untagged + `"}`, nil, http.StatusForbidden},
+		{"failed lookup", valid, errors.New("AccessDeniedException"), http.StatusBadGateway},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			provider := &fakeProvider{tagged: map[string]bool{testDestination: true}, taggedErr: c.taggedErr}
+			s, _ := newTestServer(t, provider, false)
+
+			w := do(s, http.MethodPost, "/v1/mirrors", testToken, c.body)
+			if w.Code != c.want {
+				t.Fatalf("POST /v1/mirrors answered %d %s, want %d", w.Code, w.Body, c.want)
+			}
+			if c.want != http.StatusAccepted {
+				if len(s.jobs.order) != 0 {
+					t.Errorf("a rejected request created %d jobs", len(s.jobs.order))
+				}
+				return
+			}
+
+			var job Job
+			decode(t, w, &job)
+			if job.Source != "alpine:3.17" || job.Destination != testDestination || job.Status != JobQueued {
+				t.Errorf("created job %+v, want a queued copy of alpine:3.17", job)
+			}
+			if location := w.Header().Get("Location"); location != "/v1/jobs/"+job.ID {
+				t.Errorf("Location = %s, want /v1/jobs/%s", location, job.ID)
+			}
+		})
+	}
+}
+
+func TestCreateMirrorWithAFullQueue(t *testing.T) {
+	provider := &fakeProvider{tagged: map[string]bool{testDestination: true}}
+	s, _ := newTestServer(t, provider, false)
+
+	for i := 0; ; i++ {
+		if err := s.queue.Submit(func(context.Context) {}); err != nil {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("the queue never filled up")
+		}
+	}
+
+	w := do(s, http.MethodPost, "/v1/mirrors", testToken, `{"source":"alpine:3.17","destination":"`+testDestination+`"}`)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /v1/mirrors with a full queue answered %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if len(s.jobs.order) != 0 || len(s.jobs.jobs) != 0 {
+		t.Error("the job which could not be queued was kept")
+	}
+}
+
+func TestJobStates(t *testing.T) {
+	provider := &fakeProvider{release: make(chan struct{}), tagged: map[string]bool{testDestination: true}}
+	s, startQueue := newTestServer(t, provider, false)
+
+	w := do(s, http.MethodPost, "/v1/mirrors", testToken, `{"source":"alpine:3.17","destination":"`+testDestination+`"}`)
+	var created Job
+	decode(t, w, &created)
+
+	queued := waitForJob(t, s, created.ID, JobQueued)
+	if queued.StartedAt != nil || queued.FinishedAt != nil {
+		t.Errorf("queued job %+v has started", queued)
+	}
+
+	startQueue()
+	running := waitForJob(t, s, created.ID, JobRunning)
+	if running.StartedAt == nil || running.FinishedAt != nil {
+		t.Errorf("running job %+v has not started or has finished", running)
+	}
+
+	close(provider.release)
+	succeeded := waitForJob(t, s, created.ID, JobSucceeded)
+	if succeeded.FinishedAt == nil || succeeded.Error != "" {
+		t.Errorf("succeeded job %+v has not finished or has an error", succeeded)
+	}
+	if succeeded.Report == nil || succeeded.Report.Command != "copy" || len(succeeded.Report.Records) != 1 || succeeded.Report.Records[0].Action != string(mirror.OutcomeCopied) {
+		t.Errorf("succeeded job has the report %+v, want the copy", succeeded.Report)
+	}
+	if len(provider.copied) != 1 || provider.copied[0] != "alpine:3.17 "+testDestination {
+		t.Errorf("copied %v, want alpine:3.17 once", provider.copied)
+	}
+}
+
+func TestFailedJob(t *testing.T) {
+	provider := &fakeProvider{copyErr: errors.New("unauthorized: Bearer abc.def"), tagged: map[string]bool{testDestination: true}}
+	s, _ := newTestServer(t, provider, true)
+
+	var created Job
+	decode(t, do(s, http.MethodPost, "/v1/mirrors", testToken, `{"source":"alpine:3.17","destination":"`+testDestination+`"}`), &created)
+
+	failed := waitForJob(t, s, created.ID, JobFailed)
+	if failed.Error == "" || strings.Contains(failed.Error, "abc.def") {
+		t.Errorf("failed job has the error %q, want the redacted copy error", failed.Error)
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, true)
+
+	if w := do(s, http.MethodGet, "/v1/jobs/0f8fad5b-d9cb-469f-a165-70867728950e", testToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET of an unknown job answered %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := do(s, http.MethodDelete, "/v1/jobs/0f8fad5b-d9cb-469f-a165-70867728950e", testToken, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE of a job answered %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestListMirrors(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, true)
+
+	w := do(s, http.MethodGet, "/v1/mirrors", testToken, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /v1/mirrors answered %d", w.Code)
+	}
+	var listed struct {
+		Command string `json:"command"`
+	}
+	decode(t, w, &listed)
+	if listed.Command != "list" || !strings.Contains(w.Body.String(), testDestination) {
+		t.Errorf("GET /v1/mirrors returned %s, want the list report", w.Body)
+	}
+}
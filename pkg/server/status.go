@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/redact"
+	"ecr-mirror-sync/pkg/report"
+	"sort"
+	"sync"
+	"time"
+)
+
+// readyCacheTTL is how long the outcome of a credentials check answers /readyz.
+const readyCacheTTL = 30 * time.Second
+
+// RunStatus summarises the last sync run.
+type RunStatus struct {
+	Error      string        `json:"error,omitempty"`
+	FinishedAt time.Time     `json:"finishedAt"`
+	RunID      string        `json:"runId"`
+	StartedAt  time.Time     `json:"startedAt"`
+	Totals     report.Totals `json:"totals"`
+}
+
+// RepositoryStatus is the last outcome of a single image:tag, from either a sync or a copy job.
+type RepositoryStatus struct {
+	Action          string    `json:"action"`
+	Destination     string    `json:"destination"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	ECRDigest       string    `json:"ecrDigest,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	RunID           string    `json:"runId"`
+	Source          string    `json:"source"`
+	Tag             string    `json:"tag"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+	UpstreamDigest  string    `json:"upstreamDigest,omitempty"`
+}
+
+// Status is the body of GET /status.
+type Status struct {
+	LastSync     *RunStatus         `json:"lastSync,omitempty"`
+	Repositories []RepositoryStatus `json:"repositories"`
+}
+
+// statusStore keeps the outcome of the last run of every repository and tag.
+type statusStore struct {
+	mu           sync.Mutex
+	lastSync     *RunStatus
+	repositories map[string]RepositoryStatus // keyed by destination and tag
+}
+
+func newStatusStore() *statusStore {
+	return &statusStore{repositories: map[string]RepositoryStatus{}}
+}
+
+// observe records the outcome of a finished run, err is the error the run returned.
+func (s *statusStore) observe(command string, result *mirror.Result, err error) {
+	if result == nil {
+		return
+	}
+	rep := result.Report(command)
+	finishedAt := result.StartedAt.Add(result.Duration).UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if command == "sync" {
+		s.lastSync = &RunStatus{
+			Error:      redact.Error(err),
+			FinishedAt: finishedAt,
+			RunID:      rep.RunID,
+			StartedAt:  rep.StartedAt.UTC(),
+			Totals:     rep.Totals,
+		}
+	}
+
+	for _, record := range rep.Records {
+		s.repositories[record.Destination+":"+record.Tag] = RepositoryStatus{
+			Action:          record.Action,
+			Destination:     record.Destination,
+			DurationSeconds: record.Duration.Seconds(),
+			ECRDigest:       record.ECRDigest,
+			Error:           record.Error,
+			RunID:           rep.RunID,
+			Source:          record.Source,
+			Tag:             record.Tag,
+			UpdatedAt:       finishedAt,
+			UpstreamDigest:  record.UpstreamDigest,
+		}
+	}
+}
+
+// status returns a snapshot of the store, repositories are ordered by destination and tag.
+func (s *statusStore) status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{Repositories: make([]RepositoryStatus, 0, len(s.repositories))}
+	if s.lastSync != nil {
+		lastSync := *s.lastSync
+		status.LastSync = &lastSync
+	}
+	for _, repository := range s.repositories {
+		status.Repositories = append(status.Repositories, repository)
+	}
+	sort.Slice(status.Repositories, func(i, j int) bool {
+		a, b := status.Repositories[i], status.Repositories[j]
+		if a.Destination != b.Destination {
+			return a.Destination < b.Destination
+		}
+		return a.Tag < b.Tag
+	})
+	return status
+}
+
+// readiness caches the outcome of check for readyCacheTTL, so probes do not call AWS on every request.
+type readiness struct {
+	mu        sync.Mutex
+	check     func(ctx context.Context) error
+	checkedAt time.Time
+	err       error
+}
+
+func (r *readiness) ready(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.checkedAt.IsZero() || time.Since(r.checkedAt) > readyCacheTTL {
+		r.err = r.check(ctx)
+		r.checkedAt = time.Now()
+	}
+	return r.err
+}
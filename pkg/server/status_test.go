@@ -0,0 +1,119 @@
+package server
+
+import (
+	"ecr-mirror-sync/pkg/mirror"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyzCachesTheCheck(t *testing.T) {
+	provider := &fakeProvider{checkErr: errors.New("ExpiredTokenException")}
+	s, _ := newTestServer(t, provider, true)
+
+	for i := 0; i < 2; i++ {
+		if w := do(s, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("GET /readyz with failing credentials answered %d, want %d", w.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if provider.checks != 1 {
+		t.Errorf("checked the credentials %d times within %s, want once", provider.checks, readyCacheTTL)
+	}
+
+	// The failure is cached until readyCacheTTL passed, even once the credentials are valid.
+	provider.checkErr = nil
+	if w := do(s, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz answered %d before the cached failure expired, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	s.readiness.checkedAt = time.Now().Add(-readyCacheTTL - time.Second)
+	for i := 0; i < 2; i++ {
+		if w := do(s, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusOK {
+			t.Fatalf("GET /readyz once the check passed answered %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	if provider.checks != 2 {
+		t.Errorf("checked the credentials %d times, want twice", provider.checks)
+	}
+
+	// A success is cached too, a failing check is only noticed once it expired.
+	provider.checkErr = errors.New("AccessDeniedException")
+	if w := do(s, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusOK {
+		t.Errorf("GET /readyz answered %d before the cached success expired, want %d", w.Code, http.StatusOK)
+	}
+	s.readiness.checkedAt = time.Now().Add(-readyCacheTTL - time.Second)
+	if w := do(s, http.MethodGet, "/readyz", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz answered %d once the check failed again, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// runResult returns the result of run runID which handled repositories at startedAt.
+func runResult(runID string, startedAt time.Time, repositories ...mirror.MirrorRepository) *mirror.Result {
+	r := result(runID, repositories...)
+	r.Duration, r.StartedAt = time.Minute, startedAt
+	return r
+}
+
+func TestStatusKeepsTheLastOutcomePerTag(t *testing.T) {
+	store := newStatusStore()
+	first, second := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 1, 1, 6, 0, 0, 0, time.UTC)
+	const redis = "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/redis"
+
+	store.observe("sync", runResult("first", first,
+		mirror.MirrorRepository{ECRRespository: testDestination, Outcome: mirror.OutcomeCopied, UpstreamImage: "alpine", UpstreamTag: "3.17"},
+		mirror.MirrorRepository{ECRRespository: testDestination, Err: errors.New("unauthorized: Bearer abc.def"), Outcome: mirror.OutcomeFailed, UpstreamImage: "alpine", UpstreamTag: "3.16"},
+	), &mirror.FailureError{Failed: 1, Total: 2})
+	store.observe("copy", runResult("copy", first.Add(time.Hour),
+		mirror.MirrorRepository{ECRRespository: redis, Outcome: mirror.OutcomeCopied, UpstreamImage: "redis", UpstreamTag: "7"},
+	), nil)
+	store.observe("sync", runResult("second", second,
+		mirror.MirrorRepository{ECRRespository: testDestination, Outcome: mirror.OutcomeUpToDate, UpstreamImage: "alpine", UpstreamTag: "3.16"},
+	), nil)
+	store.observe("sync", nil, errors.New("could not list the repositories"))
+
+	status := store.status()
+	if status.LastSync == nil || status.LastSync.RunID != "second" || status.LastSync.Error != "" || !status.LastSync.FinishedAt.Equal(second.Add(time.Minute)) {
+		t.Errorf("last sync = %+v, want the second run", status.LastSync)
+	}
+
+	var got []string
+	for _, repository := range status.Repositories {
+		got = append(got, strings.Join([]string{repository.Destination, repository.Tag, repository.Action, repository.RunID}, " "))
+		if strings.Contains(repository.Error, "abc.def") {
+			t.Errorf("status of %s:%s leaks the token: %s", repository.Destination, repository.Tag, repository.Error)
+		}
+	}
+	want := []string{
+		testDestination + " 3.16 " + string(mirror.OutcomeUpToDate) + " second",
+		testDestination + " 3.17 " + string(mirror.OutcomeCopied) + " first",
+		redis + " 7 " + string(mirror.OutcomeCopied) + " copy",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("repositories:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStatusRecordsTheErrorOfTheLastSync(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, true)
+
+	var empty Status
+	decode(t, do(s, http.MethodGet, "/status", testToken, ""), &empty)
+	if empty.LastSync != nil || empty.Repositories == nil || len(empty.Repositories) != 0 {
+		t.Errorf("status before the first run = %+v, want no sync and no repositories", empty)
+	}
+
+	s.Observe("sync", runResult("failed", time.Now(),
+		mirror.MirrorRepository{ECRRespository: testDestination, Err: errors.New("denied"), Outcome: mirror.OutcomeFailed, UpstreamImage: "alpine", UpstreamTag: "3.17"},
+	), &mirror.FailureError{Failed: 1, Total: 1})
+
+	var status Status
+	decode(t, do(s, http.MethodGet, "/status", testToken, ""), &status)
+	if status.LastSync == nil || status.LastSync.Error != "1 of 1 mirrors failed" || status.LastSync.Totals.Failed != 1 {
+		t.Errorf("last sync = %+v, want the failed run", status.LastSync)
+	}
+	if len(status.Repositories) != 1 || status.Repositories[0].Error != "denied" {
+		t.Errorf("repositories = %+v, want the failed tag", status.Repositories)
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"ecr-mirror-sync/pkg/mirror"
+	"ecr-mirror-sync/pkg/redact"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	log "github.com/sirupsen/logrus"
+)
+
+// Registries whose push webhooks are received on /v1/webhooks/{registry}.
+const (
+	WebhookDockerHub = "dockerhub"
+	WebhookGHCR      = "ghcr"
+	WebhookHarbor    = "harbor"
+	WebhookQuay      = "quay"
+)
+
+// webhookSecretHeader carries the shared secret for registries which can set custom headers.
+const webhookSecretHeader = "X-Webhook-Secret"
+
+// ErrInvalidSignature is returned for webhooks which do not carry a valid secret or signature.
+var ErrInvalidSignature = errors.New("invalid webhook secret or signature")
+
+// pushEvent is a push of Tags to the upstream image Image, normalized like docker.io/library/alpine.
+type pushEvent struct {
+	Image string
+	Tags  []string
+}
+
+// webhookParser extracts the push of a webhook payload, a nil event means the payload is not a push.
+type webhookParser func(r *http.Request, body []byte) (*pushEvent, error)
+
+var webhookParsers = map[string]webhookParser{
+	WebhookDockerHub: parseDockerHubWebhook,
+	WebhookGHCR:      parseGHCRWebhook,
+	WebhookHarbor:    parseHarborWebhook,
+	WebhookQuay:      parseQuayWebhook,
+}
+
+// LoadWebhookSecret reads the shared webhook secret from the first line of path.
+func LoadWebhookSecret(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read webhook secret: %w", err)
+	}
+	secret := strings.TrimSpace(strings.SplitN(string(raw), "\n", 2)[0])
+	if secret == "" {
+		return "", fmt.Errorf("no webhook secret in %s", path)
+	}
+	redact.Register(secret)
+	return secret, nil
+}
+
+// handleWebhook validates a registry push webhook and syncs the tagged repositories mirroring the pushed tags.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	registry := strings.TrimPrefix(r.URL.Path, "/v1/webhooks/")
+	parse, ok := webhookParsers[registry]
+	if !ok || s.webhookSecret == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no webhook receiver for %q", registry))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if err := verifyWebhook(registry, s.webhookSecret, r, body); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	push, err := parse(r, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s webhook: %w", registry, err))
+		return
+	}
+	if push == nil || len(push.Tags) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	job := s.jobs.add(Job{Source: push.Image, Tags: push.Tags})
+	if err := s.queuePush(job.ID, push); err != nil {
+		s.jobs.remove(job.ID)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	log.WithFields(log.Fields{
+		"job_id":              job.ID,
+		"registry":            registry,
+		mirror.LogFieldSource: push.Image,
+		mirror.LogFieldTag:    strings.Join(push.Tags, ","),
+	}).Info("push webhook received")
+
+	s.writeJob(w, job)
+}
+
+// pushBatch collects the pushes received while a webhook sync is queued, that single sync handles all of them.
+type pushBatch struct {
+	jobs   []string
+	pushes []*pushEvent
+}
+
+// queuePush adds push to the queued webhook sync, or queues a new one when none is waiting. A burst of
+// webhooks is synced by one run, which never overlaps scheduled syncs or other jobs.
+func (s *Server) queuePush(jobID string, push *pushEvent) error {
+	s.pushesMu.Lock()
+	defer s.pushesMu.Unlock()
+
+	if s.pushes != nil {
+		s.pushes.jobs = append(s.pushes.jobs, jobID)
+		s.pushes.pushes = append(s.pushes.pushes, push)
+		return nil
+	}
+
+	batch := &pushBatch{jobs: []string{jobID}, pushes: []*pushEvent{push}}
+	if err := s.queue.Submit(func(ctx context.Context) { s.syncPushes(ctx, batch) }); err != nil {
+		return err
+	}
+	s.pushes = batch
+	return nil
+}
+
+// syncPushes syncs the repositories mirroring the pushes of batch, pushes received from now on queue another sync.
+func (s *Server) syncPushes(ctx context.Context, batch *pushBatch) {
+	s.pushesMu.Lock()
+	if s.pushes == batch {
+		s.pushes = nil
+	}
+	jobs, pushes := batch.jobs, batch.pushes
+	s.pushesMu.Unlock()
+
+	s.runJobs(ctx, jobs, "webhook", func(ctx context.Context, run Run) (*mirror.Result, error) {
+		return run.SyncMatching(ctx, func(m mirror.MirrorRepository) bool {
+			for _, push := range pushes {
+				if push.matches(m) {
+					return true
+				}
+			}
+			return false
+		})
+	})
+}
+
+// matches reports whether m mirrors one of the pushed tags.
+func (e *pushEvent) matches(m mirror.MirrorRepository) bool {
+	if normalizeImage(m.UpstreamImage) != e.Image {
+		return false
+	}
+	for _, tag := range e.Tags {
+		if tag == m.UpstreamTag {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyWebhook checks the GitHub HMAC signature of GHCR webhooks, and the shared secret of the other registries.
+// Docker Hub and Quay can not sign or set headers, their webhook URL carries the secret as ?secret=.
+// Harbor sends its configured auth header as Authorization.
+func verifyWebhook(registry, secret string, r *http.Request, body []byte) error {
+	if registry == WebhookGHCR {
+		signature := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		presented, err := hex.DecodeString(signature)
+		if err != nil || signature == "" {
+			return ErrInvalidSignature
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if !hmac.Equal(presented, mac.Sum(nil)) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	for _, presented := range []string{
+		r.URL.Query().Get("secret"),
+		r.Header.Get(webhookSecretHeader),
+		strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+	} {
+		if presented != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(secret)) == 1 {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// normalizeImage returns the fully qualified name of image, e.g. docker.io/library/alpine for alpine.
+func normalizeImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return named.Name()
+}
+
+func parseDockerHubWebhook(r *http.Request, body []byte) (*pushEvent, error) {
+	var payload struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Repository.RepoName == "" || payload.PushData.Tag == "" {
+		return nil, errors.New("missing repository or tag")
+	}
+	return &pushEvent{
+		Image: normalizeImage("docker.io/" + payload.Repository.RepoName),
+		Tags:  []string{payload.PushData.Tag},
+	}, nil
+}
+
+func parseGHCRWebhook(r *http.Request, body []byte) (*pushEvent, error) {
+	switch r.Header.Get("X-GitHub-Event") {
+	case "package", "registry_package":
+	default:
+		return nil, nil // e.g. ping
+	}
+
+	type ghPackage struct {
+		Name           string `json:"name"`
+		Namespace      string `json:"namespace"`
+		PackageType    string `json:"package_type"`
+		PackageVersion struct {
+			ContainerMetadata struct {
+				Tag struct {
+					Name string `json:"name"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	}
+	var payload struct {
+		Action          string     `json:"action"`
+		Package         *ghPackage `json:"package"`
+		RegistryPackage *ghPackage `json:"registry_package"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	pkg := payload.Package
+	if pkg == nil {
+		pkg = payload.RegistryPackage
+	}
+	if pkg == nil || payload.Action != "published" || !strings.EqualFold(pkg.PackageType, "container") {
+		return nil, nil
+	}
+	if pkg.Namespace == "" || pkg.Name == "" {
+		return nil, errors.New("missing package namespace or name")
+	}
+
+	event := &pushEvent{Image: normalizeImage(strings.ToLower(fmt.Sprintf("ghcr.io/%s/%s", pkg.Namespace, pkg.Name)))}
+	if tag := pkg.PackageVersion.ContainerMetadata.Tag.Name; tag != "" {
+		event.Tags = []string{tag}
+	}
+	return event, nil
+}
+
+func parseQuayWebhook(r *http.Request, body []byte) (*pushEvent, error) {
+	var payload struct {
+		DockerURL   string   `json:"docker_url"`
+		UpdatedTags []string `json:"updated_tags"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.DockerURL == "" {
+		return nil, errors.New("missing docker_url")
+	}
+	return &pushEvent{Image: normalizeImage(payload.DockerURL), Tags: payload.UpdatedTags}, nil
+}
+
+func parseHarborWebhook(r *http.Request, body []byte) (*pushEvent, error) {
+	var payload struct {
+		Type      string `json:"type"`
+		EventData struct {
+			Resources []struct {
+				ResourceURL string `json:"resource_url"`
+				Tag         string `json:"tag"`
+			} `json:"resources"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Type != "PUSH_ARTIFACT" && payload.Type != "pushImage" {
+		return nil, nil
+	}
+
+	var event *pushEvent
+	for _, resource := range payload.EventData.Resources {
+		named, err := reference.ParseNormalizedNamed(resource.ResourceURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource_url %q: %w", resource.ResourceURL, err)
+		}
+		if event == nil {
+			event = &pushEvent{Image: named.Name()}
+		} else if named.Name() != event.Image {
+			return nil, errors.New("resources of more than one repository")
+		}
+
+		tag := resource.Tag
+		if tagged, ok := named.(reference.Tagged); ok && tag == "" {
+			tag = tagged.Tag()
+		}
+		if tag != "" {
+			event.Tags = append(event.Tags, tag)
+		}
+	}
+	return event, nil
+}
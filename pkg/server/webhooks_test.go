@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"ecr-mirror-sync/pkg/daemon"
+	"ecr-mirror-sync/pkg/mirror"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret-value"
+
+// signGHCR returns the X-Hub-Signature-256 of body signed with secret.
+func signGHCR(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	const body = `{"action":"published"}`
+
+	for _, c := range []struct {
+		name     string
+		registry string
+		target   string
+		header   http.Header
+		valid    bool
+	}{
+		{"ghcr valid signature", WebhookGHCR, "/", http.Header{"X-Hub-Signature-256": {signGHCR(testWebhookSecret, body)}}, true},
+		{"ghcr wrong signature", WebhookGHCR, "/", http.Header{"X-Hub-Signature-256": {signGHCR("other-secret", body)}}, false},
+		{"ghcr signature of another body", WebhookGHCR, "/", http.Header{"X-Hub-Signature-256": {signGHCR(testWebhookSecret, body+" ")}}, false},
+		{"ghcr invalid signature", WebhookGHCR, "/", http.Header{"X-Hub-Signature-256": {"sha256=not-hex"}}, false},
+		{"ghcr missing signature", WebhookGHCR, "/", nil, false},
+		{"ghcr secret instead of signature", WebhookGHCR, "/?secret=" + testWebhookSecret, nil, false},
+		{"dockerhub query secret", WebhookDockerHub, "/?secret=" + testWebhookSecret, nil, true},
+		{"dockerhub wrong query secret", WebhookDockerHub, "/?secret=wrong", nil, false},
+		{"dockerhub missing secret", WebhookDockerHub, "/", nil, false},
+		{"quay query secret", WebhookQuay, "/?secret=" + testWebhookSecret, nil, true},
+		{"quay wrong query secret", WebhookQuay, "/?secret=" + testWebhookSecret + "x", nil, false},
+		{"quay missing secret", WebhookQuay, "/", nil, false},
+		{"quay header secret", WebhookQuay, "/", http.Header{webhookSecretHeader: {testWebhookSecret}}, true},
+		{"quay wrong header secret", WebhookQuay, "/", http.Header{webhookSecretHeader: {"wrong"}}, false},
+		{"harbor authorization", WebhookHarbor, "/", http.Header{"Authorization": {testWebhookSecret}}, true},
+		{"harbor bearer authorization", WebhookHarbor, "/", http.Header{"Authorization": {"Bearer " + testWebhookSecret}}, true},
+		{"harbor wrong authorization", WebhookHarbor, "/", http.Header{"Authorization": {"Bearer wrong"}}, false},
+		{"harbor empty authorization", WebhookHarbor, "/", http.Header{"Authorization": {"Bearer "}}, false},
+		{"harbor missing authorization", WebhookHarbor, "/", nil, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, c.target, nil)
+			for name, values := range c.header {
+				r.Header[name] = values
+			}
+
+			err := verifyWebhook(c.registry, testWebhookSecret, r, []byte(body))
+			if c.valid && err != nil {
+				t.Errorf("verifyWebhook rejected a valid webhook: %v", err)
+			}
+			if !c.valid && err != ErrInvalidSignature {
+				t.Errorf("verifyWebhook returned %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+func TestParseWebhooks(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		registry string
+		event    string // X-GitHub-Event of GHCR webhooks
+		body     string
+		want     *pushEvent
+		invalid  bool
+	}{
+		{
+			name:     "dockerhub push",
+			registry: WebhookDockerHub,
+			body:     `{"push_data":{"pushed_at":1650000000,"pusher":"svendowideit","tag":"3.17"},"repository":{"name":"alpine","namespace":"library","repo_name":"library/alpine"}}`,
+			want:     &pushEvent{Image: "docker.io/library/alpine", Tags: []string{"3.17"}},
+		},
+		{
+			name:     "dockerhub push of a user repository",
+			registry: WebhookDockerHub,
+			body:     `{"push_data":{"tag":"latest"},"repository":{"repo_name":"svendowideit/testhook"}}`,
+			want:     &pushEvent{Image: "docker.io/svendowideit/testhook", Tags: []string{"latest"}},
+		},
+		{name: "dockerhub without tag", registry: WebhookDockerHub, body: `{"push_data":{},"repository":{"repo_name":"library/alpine"}}`, invalid: true},
+		{name: "dockerhub invalid json", registry: WebhookDockerHub, body: `{"push_data":`, invalid: true},
+		{
+			name:     "ghcr package published",
+			registry: WebhookGHCR,
+			event:    "package",
+			body:     `{"action":"published","package":{"name":"App","namespace":"Acme","package_type":"CONTAINER","package_version":{"container_metadata":{"tag":{"name":"v1.2.0","digest":"sha256:0123"}}}}}`,
+			want:     &pushEvent{Image: "ghcr.io/acme/app", Tags: []string{"v1.2.0"}},
+		},
+		{
+			name:     "ghcr registry package published",
+			registry: WebhookGHCR,
+			event:    "registry_package",
+			body:     `{"action":"published","registry_package":{"name":"app","namespace":"acme","package_type":"container","package_version":{"container_metadata":{"tag":{"name":"latest"}}}}}`,
+			want:     &pushEvent{Image: "ghcr.io/acme/app", Tags: []string{"latest"}},
+		},
+		{
+			name:     "ghcr untagged version",
+			registry: WebhookGHCR,
+			event:    "package",
+			body:     `{"action":"published","package":{"name":"app","namespace":"acme","package_type":"container","package_version":{"container_metadata":{"tag":{"name":""}}}}}`,
+			want:     &pushEvent{Image: "ghcr.io/acme/app"},
+		},
+		{name: "ghcr ping", registry: WebhookGHCR, event: "ping", body: `{"zen":"Keep it logically awesome."}`},
+		{name: "ghcr package updated", registry: WebhookGHCR, event: "package", body: `{"action":"updated","package":{"name":"app","namespace":"acme","package_type":"container"}}`},
+		{name: "ghcr npm package", registry: WebhookGHCR, event: "package", body: `{"action":"published","package":{"name":"app","namespace":"acme","package_type":"npm"}}`},
+		{name: "ghcr without namespace", registry: WebhookGHCR, event: "package", body: `{"action":"published","package":{"name":"app","package_type":"container"}}`, invalid: true},
+		{
+			name:     "quay push",
+			registry: WebhookQuay,
+			body:     `{"name":"repository","repository":"mynamespace/repository","namespace":"mynamespace","docker_url":"quay.io/mynamespace/repository","homepage":"https://quay.io/repository/mynamespace/repository","updated_tags":["latest","1.0"]}`,
+			want:     &pushEvent{Image: "quay.io/mynamespace/repository", Tags: []string{"latest", "1.0"}},
+		},
+		{name: "quay without docker_url", registry: WebhookQuay, body: `{"updated_tags":["latest"]}`, invalid: true},
+		{
+			name:     "harbor push artifact",
+			registry: WebhookHarbor,
+			body:     `{"type":"PUSH_ARTIFACT","occur_at":1650000000,"operator":"admin","event_data":{"resources":[{"digest":"sha256:0123","tag":"v2","resource_url":"harbor.example.com/library/nginx:v2"}],"repository":{"name":"nginx","namespace":"library","repo_full_name":"library/nginx"}}}`,
+			want:     &pushEvent{Image: "harbor.example.com/library/nginx", Tags: []string{"v2"}},
+		},
+		{
+			name:     "harbor tag from the resource url",
+			registry: WebhookHarbor,
+			body:     `{"type":"pushImage","event_data":{"resources":[{"resource_url":"harbor.example.com/library/nginx:v2"},{"resource_url":"harbor.example.com/library/nginx:latest"}]}}`,
+			want:     &pushEvent{Image: "harbor.example.com/library/nginx", Tags: []string{"v2", "latest"}},
+		},
+		{name: "harbor delete", registry: WebhookHarbor, body: `{"type":"DELETE_ARTIFACT","event_data":{"resources":[{"tag":"v2","resource_url":"harbor.example.com/library/nginx:v2"}]}}`},
+		{name: "harbor two repositories", registry: WebhookHarbor, body: `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"resource_url":"harbor.example.com/library/nginx:v2"},{"resource_url":"harbor.example.com/library/redis:v2"}]}}`, invalid: true},
+		{name: "harbor invalid resource url", registry: WebhookHarbor, body: `{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"resource_url":"Harbor.example.com/UPPER:v2"}]}}`, invalid: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/webhooks/"+c.registry, nil)
+			if c.event != "" {
+				r.Header.Set("X-GitHub-Event", c.event)
+			}
+
+			got, err := webhookParsers[c.registry](r, []byte(c.body))
+			if c.invalid {
+				if err == nil {
+					t.Errorf("parsed the invalid payload as %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", c.want) {
+				t.Errorf("parsed %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPushEventMatches(t *testing.T) {
+	push := &pushEvent{Image: "docker.io/library/alpine", Tags: []string{"3.16", "3.17"}}
+
+	for _, c := range []struct {
+		image, tag string
+		want       bool
+	}{
+		{"alpine", "3.17", true},
+		{"library/alpine", "3.16", true},
+		{"docker.io/library/alpine", "3.17", true},
+		{"index.docker.io/library/alpine", "3.17", true},
+		{"alpine", "3.15", false},
+		{"alpine", "latest", false},
+		{"ghcr.io/library/alpine", "3.17", false},
+		{"myorg/alpine", "3.17", false},
+	} {
+		if got := push.matches(mirror.MirrorRepository{UpstreamImage: c.image, UpstreamTag: c.tag}); got != c.want {
+			t.Errorf("%s:%s matches = %t, want %t", c.image, c.tag, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	for image, want := range map[string]string{
+		"alpine":                           "docker.io/library/alpine",
+		"library/alpine":                   "docker.io/library/alpine",
+		"docker.io/library/alpine":         "docker.io/library/alpine",
+		"bitnami/redis":                    "docker.io/bitnami/redis",
+		"quay.io/prometheus/node-exporter": "quay.io/prometheus/node-exporter",
+		"localhost:5000/app":               "localhost:5000/app",
+		"Invalid Image":                    "Invalid Image",
+	} {
+		if got := normalizeImage(image); got != want {
+			t.Errorf("normalizeImage(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	s, _ := newTestServer(t, &fakeProvider{}, false)
+	const push = `{"push_data":{"tag":"3.17"},"repository":{"repo_name":"library/alpine"}}`
+
+	for _, c := range []struct {
+		name, method, target, body string
+		want                       int
+	}{
+		{"wrong secret", http.MethodPost, "/v1/webhooks/dockerhub?secret=wrong", push, http.StatusUnauthorized},
+		{"missing secret", http.MethodPost, "/v1/webhooks/dockerhub", push, http.StatusUnauthorized},
+		{"unknown registry", http.MethodPost, "/v1/webhooks/gitlab?secret=" + testWebhookSecret, push, http.StatusNotFound},
+		{"get", http.MethodGet, "/v1/webhooks/dockerhub?secret=" + testWebhookSecret, "", http.StatusMethodNotAllowed},
+		{"invalid payload", http.MethodPost, "/v1/webhooks/dockerhub?secret=" + testWebhookSecret, `{"push_data":{}}`, http.StatusBadRequest},
+		{"not a push", http.MethodPost, "/v1/webhooks/harbor?secret=" + testWebhookSecret, `{"type":"DELETE_ARTIFACT"}`, http.StatusOK},
+	} {
+		if w := do(s, c.method, c.target, "", c.body); w.Code != c.want {
+			t.Errorf("%s: %s %s answered %d %s, want %d", c.name, c.method, c.target, w.Code, w.Body, c.want)
+		}
+	}
+	if len(s.jobs.order) != 0 {
+		t.Errorf("rejected and ignored webhooks created %d jobs", len(s.jobs.order))
+	}
+
+	disabled := New(daemon.NewQueue(), &fakeProvider{}, nil, "")
+	if w := do(disabled, http.MethodPost, "/v1/webhooks/dockerhub?secret=", "", push); w.Code != http.StatusNotFound {
+		t.Errorf("a server without webhook secret answered %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestWebhooksAreSyncedTogether(t *testing.T) {
+	provider := &fakeProvider{upstream: []mirror.MirrorRepository{
+		{UpstreamImage: "alpine", UpstreamTag: "3.16"},
+		{UpstreamImage: "alpine", UpstreamTag: "3.17"},
+		{UpstreamImage: "alpine", UpstreamTag: "3.18"},
+		{UpstreamImage: "quay.io/prometheus/node-exporter", UpstreamTag: "v1.5.0"},
+		{UpstreamImage: "redis", UpstreamTag: "7"},
+	}}
+	s, startQueue := newTestServer(t, provider, false)
+
+	var ids []string
+	for _, c := range []struct{ registry, body string }{
+		{WebhookDockerHub, `{"push_data":{"tag":"3.17"},"repository":{"repo_name":"library/alpine"}}`},
+		{WebhookQuay, `{"docker_url":"quay.io/prometheus/node-exporter","updated_tags":["v1.5.0"]}`},
+		{WebhookDockerHub, `{"push_data":{"tag":"3.16"},"repository":{"repo_name":"library/alpine"}}`},
+	} {
+		w := do(s, http.MethodPost, "/v1/webhooks/"+c.registry+"?secret="+testWebhookSecret, "", c.body)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("%s webhook answered %d %s", c.registry, w.Code, w.Body)
+		}
+		var job Job
+		decode(t, w, &job)
+		ids = append(ids, job.ID)
+	}
+
+	startQueue()
+	for _, id := range ids {
+		waitForJob(t, s, id, JobSucceeded)
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.syncs != 1 {
+		t.Errorf("the webhooks were synced by %d runs, want one", provider.syncs)
+	}
+	sort.Strings(provider.synced)
+	if got, want := strings.Join(provider.synced, " "), "alpine:3.16 alpine:3.17 quay.io/prometheus/node-exporter:v1.5.0"; got != want {
+		t.Errorf("synced %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Package shutdown stops commands on SIGINT or SIGTERM, while leaving in-flight work a grace period to finish.
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NotifyContext returns a copy of parent which is canceled on the first SIGINT or SIGTERM.
+// The signals are then reset to their default behavior, so a second one terminates the process right away.
+func NotifyContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Warnf("received %s, stopping, signal again to exit immediately", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// WithGracePeriod returns a context which carries the values of parent but is only canceled grace after
+// parent is done, or when the returned cancel function is called. Work which must not be abandoned as soon
+// as a shutdown is requested, e.g. in-flight copies or the final report, runs with this context.
+func WithGracePeriod(parent context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(detached{parent})
+
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// detached carries the values of a context without its deadline and cancellation.
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}             { return nil }
+func (d detached) Err() error                        { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
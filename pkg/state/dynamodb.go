@@ -0,0 +1,98 @@
+package state
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/retry"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// dynamoDBBatchSize is the maximum number of items of a BatchWriteItem request.
+const dynamoDBBatchSize = 25
+
+// DynamoDBStore keeps one item per entry in a table whose partition key is the string attribute "key".
+type DynamoDBStore struct {
+	Client    dynamodbiface.DynamoDBAPI
+	RetryOpts *retry.Options // Resubmits the items DynamoDB left unprocessed, nil never does
+	Table     string
+}
+
+func NewDynamoDBStore(sess *session.Session, table string, retryOpts *retry.Options) *DynamoDBStore {
+	return &DynamoDBStore{
+		Client:    dynamodb.New(sess),
+		RetryOpts: retryOpts,
+		Table:     table,
+	}
+}
+
+func (s *DynamoDBStore) Load(ctx context.Context) (map[string]Entry, error) {
+	entries := map[string]Entry{}
+
+	var decodeErr error
+	err := s.Client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		ConsistentRead: aws.Bool(true),
+		TableName:      aws.String(s.Table),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var entry Entry
+			if decodeErr = dynamodbattribute.UnmarshalMap(item, &entry); decodeErr != nil {
+				return false
+			}
+			entries[entry.Key] = entry
+		}
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state from dynamodb table %s: %w", s.Table, err)
+	}
+	return entries, nil
+}
+
+func (s *DynamoDBStore) Put(ctx context.Context, entries []Entry) error {
+	for start := 0; start < len(entries); start += dynamoDBBatchSize {
+		end := start + dynamoDBBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		requests := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, entry := range entries[start:end] {
+			item, err := dynamodbattribute.MarshalMap(entry)
+			if err != nil {
+				return fmt.Errorf("could not encode state entry %s: %w", entry.Key, err)
+			}
+			requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		}
+
+		if err := s.batchWrite(ctx, requests); err != nil {
+			return fmt.Errorf("could not write state to dynamodb table %s: %w", s.Table, err)
+		}
+	}
+	return nil
+}
+
+// batchWrite writes requests, resubmitting the items DynamoDB left unprocessed with the backoff of RetryOpts.
+// DynamoDB leaves items unprocessed when the throughput of the table is exceeded, which is retried like throttling.
+func (s *DynamoDBStore) batchWrite(ctx context.Context, requests []*dynamodb.WriteRequest) error {
+	return retry.Do(ctx, s.RetryOpts, func() error {
+		out, err := s.Client.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{s.Table: requests},
+		})
+		if err != nil {
+			return err
+		}
+		if requests = out.UnprocessedItems[s.Table]; len(requests) > 0 {
+			return awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, fmt.Sprintf("%d items left unprocessed", len(requests)), nil)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/retry"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamoDB keeps the items of a table in memory. The first unprocessed BatchWriteItem calls leave
+// their last item unprocessed, like a table exceeding its throughput.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	batches     []int // Items of every BatchWriteItem call
+	items       map[string]map[string]*dynamodb.AttributeValue
+	mu          sync.Mutex
+	unprocessed int
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func (f *fakeDynamoDB) BatchWriteItemWithContext(_ aws.Context, input *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}
+	for table, requests := range input.RequestItems {
+		if len(requests) > dynamoDBBatchSize {
+			return nil, fmt.Errorf("%d items exceed the batch size", len(requests))
+		}
+		f.batches = append(f.batches, len(requests))
+		if f.unprocessed > 0 {
+			f.unprocessed--
+			out.UnprocessedItems[table] = requests[len(requests)-1:]
+			requests = requests[:len(requests)-1]
+		}
+		for _, req := range requests {
+			f.items[aws.StringValue(req.PutRequest.Item["key"].S)] = req.PutRequest.Item
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) ScanPagesWithContext(_ aws.Context, _ *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page := &dynamodb.ScanOutput{}
+	for _, item := range f.items {
+		page.Items = append(page.Items, item)
+	}
+	fn(page, true)
+	return nil
+}
+
+func testEntries(n int) []Entry {
+	entries := make([]Entry, 0, n)
+	for i := 0; i < n; i++ {
+		tag := fmt.Sprintf("3.%d", i)
+		entries = append(entries, Entry{
+			CheckedAt:      time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			Destination:    "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine",
+			Key:            Key("alpine", "123456789012.dkr.ecr.us-east-1.amazonaws.com/external/alpine", tag),
+			Outcome:        "copied",
+			Source:         "alpine",
+			Tag:            tag,
+			UpstreamDigest: "sha256:" + tag,
+		})
+	}
+	return entries
+}
+
+func TestDynamoDBStorePutAndLoad(t *testing.T) {
+	fake := newFakeDynamoDB()
+	store := &DynamoDBStore{Client: fake, Table: "state"}
+
+	entries := testEntries(60)
+	if err := store.Put(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{25, 25, 10}; fmt.Sprint(fake.batches) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", fake.batches, want)
+	}
+
+	loaded, err := store.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(entries) {
+		t.Fatalf("loaded %d entries, want %d", len(loaded), len(entries))
+	}
+	for _, entry := range entries {
+		got, ok := loaded[entry.Key]
+		if !ok {
+			t.Errorf("entry %s was not loaded", entry.Key)
+			continue
+		}
+		if got.UpstreamDigest != entry.UpstreamDigest || !got.CheckedAt.Equal(entry.CheckedAt) {
+			t.Errorf("loaded %+v, want %+v", got, entry)
+		}
+	}
+}
+
+func TestDynamoDBStoreRetriesUnprocessedItems(t *testing.T) {
+	fake := newFakeDynamoDB()
+	fake.unprocessed = 2
+	store := &DynamoDBStore{Client: fake, RetryOpts: &retry.Options{Delay: time.Millisecond, MaxRetry: 3}, Table: "state"}
+
+	if err := store.Put(context.Background(), testEntries(3)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 1, 1}; fmt.Sprint(fake.batches) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v, only the unprocessed items are resubmitted", fake.batches, want)
+	}
+	if len(fake.items) != 3 {
+		t.Errorf("wrote %d items, want 3", len(fake.items))
+	}
+}
+
+func TestDynamoDBStoreGivesUpOnUnprocessedItems(t *testing.T) {
+	for _, retryOpts := range []*retry.Options{nil, {MaxRetry: 0}, {Delay: time.Millisecond, MaxRetry: 2}} {
+		fake := newFakeDynamoDB()
+		fake.unprocessed = 10
+		store := &DynamoDBStore{Client: fake, RetryOpts: retryOpts, Table: "state"}
+
+		err := store.Put(context.Background(), testEntries(3))
+		if err == nil {
+			t.Fatalf("Put with %+v succeeded with unprocessed items", retryOpts)
+		}
+		attempts := 1
+		if retryOpts != nil {
+			attempts += retryOpts.MaxRetry
+		}
+		if len(fake.batches) != attempts {
+			t.Errorf("Put with %+v made %d attempts, want %d", retryOpts, len(fake.batches), attempts)
+		}
+		if !retry.Retryable(errors.Unwrap(err)) {
+			t.Errorf("unprocessed items error %v is not retryable", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// document is the json layout shared by the file and S3 stores.
+type document struct {
+	Entries map[string]Entry `json:"entries"`
+	Version int              `json:"version"`
+}
+
+const documentVersion = 1
+
+// FileStore keeps all entries in a single json file.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load(ctx context.Context) (map[string]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileStore) Put(ctx context.Context, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.load()
+	if err != nil {
+		return err
+	}
+	merge(stored, entries)
+
+	// Write a sibling file and rename it, readers never see a partially written state.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := encode(tmp, stored); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not write state: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) load() (map[string]Entry, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state: %w", err)
+	}
+	defer f.Close()
+	return decode(f)
+}
+
+func decode(r io.Reader) (map[string]Entry, error) {
+	var doc document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not decode state: %w", err)
+	}
+	if doc.Entries == nil {
+		doc.Entries = map[string]Entry{}
+	}
+	return doc.Entries, nil
+}
+
+func encode(w io.Writer, entries map[string]Entry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document{Entries: entries, Version: documentVersion})
+}
+
+// merge replaces the stored entries with the same Key as entries.
+func merge(stored map[string]Entry, entries []Entry) {
+	for _, entry := range entries {
+		stored[entry.Key] = entry
+	}
+}
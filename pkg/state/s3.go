@@ -0,0 +1,79 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Store keeps all entries in a single json object, in the same layout as FileStore.
+type S3Store struct {
+	Bucket string
+	Client s3iface.S3API
+	Key    string
+
+	mu sync.Mutex
+}
+
+func NewS3Store(sess *session.Session, bucket, key string) *S3Store {
+	return &S3Store{
+		Bucket: bucket,
+		Client: s3.New(sess),
+		Key:    key,
+	}
+}
+
+func (s *S3Store) Load(ctx context.Context) (map[string]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(ctx)
+}
+
+func (s *S3Store) Put(ctx context.Context, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	merge(stored, entries)
+
+	var body bytes.Buffer
+	if err := encode(&body, stored); err != nil {
+		return fmt.Errorf("could not encode state: %w", err)
+	}
+
+	_, err = s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Body:        bytes.NewReader(body.Bytes()),
+		Bucket:      aws.String(s.Bucket),
+		ContentType: aws.String("application/json"),
+		Key:         aws.String(s.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("could not write state to s3://%s/%s: %w", s.Bucket, s.Key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) load(ctx context.Context) (map[string]Entry, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return map[string]Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state from s3://%s/%s: %w", s.Bucket, s.Key, err)
+	}
+	defer out.Body.Close()
+	return decode(out.Body)
+}
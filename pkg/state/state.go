@@ -0,0 +1,99 @@
+// Package state persists what previous runs saw for every mirrored image:tag, so later runs can be incremental.
+package state
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/retry"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// maxHistory bounds the digest changes kept per entry.
+const maxHistory = 10
+
+// Entry is the state of a single upstream image:tag mirrored into an ECR repository.
+type Entry struct {
+	ChangedAt      *time.Time `json:"changedAt,omitempty"` // last time the upstream digest changed
+	CheckedAt      time.Time  `json:"checkedAt"`           // last time the digests were compared
+	CopiedAt       *time.Time `json:"copiedAt,omitempty"`  // last time the image was copied into ECR
+	Destination    string     `json:"destination"`
+	ECRDigest      string     `json:"ecrDigest,omitempty"`
+	ETag           string     `json:"etag,omitempty"` // manifest digest or ETag returned by the upstream registry
+	History        []Change   `json:"history,omitempty"`
+	Key            string     `json:"key"`
+	Outcome        string     `json:"outcome"`
+	RunID          string     `json:"runId"`
+	Source         string     `json:"source"`
+	Tag            string     `json:"tag"`
+	UpstreamDigest string     `json:"upstreamDigest,omitempty"`
+}
+
+// Change records an upstream digest seen by a run.
+type Change struct {
+	At             time.Time `json:"at"`
+	Outcome        string    `json:"outcome"`
+	RunID          string    `json:"runId"`
+	UpstreamDigest string    `json:"upstreamDigest"`
+}
+
+// Store loads and saves entries, implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns every entry by Key, an empty map when nothing was saved yet.
+	Load(ctx context.Context) (map[string]Entry, error)
+	// Put saves entries, replacing those with the same Key and keeping all others.
+	Put(ctx context.Context, entries []Entry) error
+}
+
+// Key identifies the entry of source:tag mirrored into destination.
+func Key(source, destination, tag string) string {
+	return fmt.Sprintf("%s:%s|%s", source, tag, destination)
+}
+
+// Record appends change to the history of e when its upstream digest differs from the last one seen, keeping maxHistory changes.
+func (e *Entry) Record(change Change) {
+	if change.UpstreamDigest == "" {
+		return
+	}
+	if n := len(e.History); n > 0 && e.History[n-1].UpstreamDigest == change.UpstreamDigest {
+		return
+	}
+	e.History = append(e.History, change)
+	if len(e.History) > maxHistory {
+		e.History = e.History[len(e.History)-maxHistory:]
+	}
+}
+
+// Open returns the store at location: a local file path, s3://BUCKET/KEY or dynamodb://TABLE.
+// Writes to DynamoDB are retried as configured by retryOpts.
+func Open(sess *session.Session, location string, retryOpts *retry.Options) (Store, error) {
+	if !strings.Contains(location, "://") {
+		return NewFileStore(location), nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewFileStore(u.Path), nil
+	case "s3":
+		key := strings.TrimPrefix(u.Path, "/")
+		if u.Host == "" || key == "" {
+			return nil, fmt.Errorf("invalid state location %q, expected s3://BUCKET/KEY", location)
+		}
+		return NewS3Store(sess, u.Host, key), nil
+	case "dynamodb":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid state location %q, expected dynamodb://TABLE", location)
+		}
+		return NewDynamoDBStore(sess, u.Host, retryOpts), nil
+	default:
+		return nil, fmt.Errorf("unknown state location scheme %q, expected a file path, s3:// or dynamodb://", u.Scheme)
+	}
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"ecr-mirror-sync/pkg/options"
+	"ecr-mirror-sync/pkg/redact"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "ecr-mirror-sync"
+
+// Span attribute keys shared by all spans of a run.
+const (
+	DigestKey     = attribute.Key("image.digest")
+	ECRDigestKey  = attribute.Key("ecr.digest")
+	RepositoryKey = attribute.Key("ecr.repository")
+	SourceKey     = attribute.Key("image.source")
+	TagKey        = attribute.Key("image.tag")
+)
+
+// Setup installs a global tracer provider which exports spans over OTLP/HTTP when opts.Endpoint is set.
+// Without an endpoint the global no-op provider is kept. The returned func flushes and stops the exporter.
+func Setup(ctx context.Context, opts *options.TracingOptions) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	clientOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create otlp trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(instrumentationName),
+			semconv.ServiceVersionKey.String(options.Version),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		// Span events leave the process, record the redacted message instead of err itself.
+		message := redact.Error(err)
+		span.RecordError(errors.New(message))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}